@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+var clipboardTimeout = 15 * time.Second
+
+// clipboardClearer clears a copied value from the clipboard after a timeout,
+// unless the clipboard has been overwritten by something else meanwhile.
+type clipboardClearer struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+	value    string
+}
+
+var copied clipboardClearer
+
+func copyToClipboard(value string) error {
+	if err := clipboard.WriteAll(value); err != nil {
+		return err
+	}
+	copied.set(value, clipboardTimeout)
+	return nil
+}
+
+func (c *clipboardClearer) set(value string, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+	// Without a timeout the value is kept, also when kp2cli exits.
+	c.value = ""
+	if timeout <= 0 {
+		return
+	}
+	c.value = value
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.timer == timer {
+			c.clear()
+		}
+	})
+	c.timer = timer
+	c.deadline = time.Now().Add(timeout)
+}
+
+// wait blocks until a pending clear is due and then clears the clipboard.
+func (c *clipboardClearer) wait() {
+	c.mu.Lock()
+	pending := c.timer != nil
+	deadline := c.deadline
+	c.mu.Unlock()
+	if pending {
+		time.Sleep(time.Until(deadline))
+	}
+	c.flush()
+}
+
+// flush clears the copied value right away.
+func (c *clipboardClearer) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+	c.clear()
+}
+
+// forget drops the copied value without touching the clipboard.
+func (c *clipboardClearer) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+	c.value = ""
+}
+
+func (c *clipboardClearer) stop() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+func (c *clipboardClearer) clear() {
+	if c.value != "" {
+		if current, err := clipboard.ReadAll(); err == nil && current == c.value {
+			clipboard.WriteAll("")
+		}
+	}
+	c.value = ""
+	c.timer = nil
+}
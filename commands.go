@@ -1,12 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"strings"
 	"syscall"
 	"text/tabwriter"
+	"time"
 	"unsafe"
 
 	"github.com/atotto/clipboard"
@@ -17,18 +20,66 @@ import (
 )
 
 var cmds = []terminal.Command{
+	{Name: "add", Help: "Add a new entry", CmdFn: addCmd, Completer: groupCompleter},
 	{Name: "cd", Help: "Change directory (path to a group)", CmdFn: cdCmd, Completer: groupCompleter},
 	{Name: "close", Help: "Close the opened database", CmdFn: closeCmd, Completer: filenameCompleter},
+	{Name: "diff", Help: "Show the changes since the last save, or against another database (-h for options)", CmdFn: diffCmd, Completer: filenameCompleter},
+	{Name: "edit", Help: "Edit the fields of an entry", CmdFn: editCmd, Completer: entryCompleter},
 	{Name: "exit", Help: "Exit this program", CmdFn: exitCmd},
+	{Name: "export", Help: "Export to a csv, json or xml file in plain text (-y to skip confirmation)", CmdFn: exportCmd, Completer: exportCompleter},
 	{Name: "find", Help: "Find entries", CmdFn: findCmd},
+	{Name: "gen", Help: "Generate a password (-h for options)", CmdFn: genCmd},
 	{Name: "help", Help: "Print help", CmdFn: helpCmd},
+	{Name: "import", Help: "Import entries from another password manager (-h for options)", CmdFn: importCmd, Completer: filenameCompleter},
+	{Name: "info", Help: "Show information about the opened database", CmdFn: infoCmd},
+	{Name: "kdf-bench", Help: "Recommend key derivation settings for a target unlock time (-h for options)", CmdFn: kdfBenchCmd},
+	{Name: "kdf-set", Help: "Change the key derivation settings used by the next save (-h for options)", CmdFn: kdfSetCmd},
+	{Name: "keygen", Help: "Generate a key file (-h for options)", CmdFn: keygenCmd, Completer: filenameCompleter},
 	{Name: "ls", Help: "List items in the pwd or specified paths", CmdFn: lsCmd, Completer: groupCompleter},
-	{Name: "open", Help: "Open a Keepass database", CmdFn: openCmd, Completer: filenameCompleter},
-	{Name: "xp", Help: "Copy password to clipboard", CmdFn: xpCmd, Completer: entryCompleter},
-	{Name: "xu", Help: "Copy username to clipboard", CmdFn: xuCmd, Completer: entryCompleter},
+	{Name: "merge", Help: "Merge another database into the opened one (-h for options)", CmdFn: mergeCmd, Completer: filenameCompleter},
+	{Name: "mkdir", Help: "Create groups (-p to create parent groups as needed)", CmdFn: mkdirCmd, Completer: groupCompleter},
+	{Name: "mv", Help: "Move or rename an entry or a group", CmdFn: mvCmd, Completer: entryCompleter},
+	{Name: "new", Help: "Create a new database file (-h for options)", CmdFn: newCmd, Completer: filenameCompleter},
+	{Name: "open", Help: "Open a Keepass database (-h for options)", CmdFn: openCmd, Completer: filenameCompleter},
+	{Name: "otp-setup", Help: "Store a one-time password secret in an entry (-h for options)", CmdFn: otpSetupCmd, Completer: entryCompleter},
+	{Name: "passwd", Help: "Change the master password or key file (-h for options)", CmdFn: passwdCmd, Completer: filenameCompleter},
+	{Name: "rm", Help: "Remove entries", CmdFn: rmCmd, Completer: entryCompleter},
+	{Name: "rmdir", Help: "Remove groups (-r to remove non-empty groups)", CmdFn: rmdirCmd, Completer: groupCompleter},
+	{Name: "save", Help: "Save the opened database", CmdFn: saveCmd},
+	{Name: "saveas", Help: "Save the opened database to a new file", CmdFn: saveasCmd, Completer: filenameCompleter},
+	{Name: "set", Help: "Show or change settings", CmdFn: setCmd, Completer: settingCompleter},
+	{Name: "source", Help: "Run the commands in a file", CmdFn: sourceCmd, Completer: filenameCompleter},
+	{Name: "show", Help: "Show an entry (-f to show protected values)", CmdFn: showCmd, Completer: entryCompleter},
+	{Name: "totp", Help: "Print the one-time password of an entry", CmdFn: totpCmd, Completer: entryCompleter},
+	{Name: "tree", Help: "List groups and entries recursively", CmdFn: treeCmd, Completer: groupCompleter},
+	{Name: "xf", Help: "Copy a field to clipboard", CmdFn: xfCmd, Completer: fieldCompleter},
+	{Name: "xl", Help: "Copy URL to clipboard", CmdFn: copyFieldCmd("URL"), Completer: entryCompleter},
+	{Name: "xn", Help: "Copy notes to clipboard", CmdFn: copyFieldCmd("Notes"), Completer: entryCompleter},
+	{Name: "xo", Help: "Copy one-time password to clipboard", CmdFn: xoCmd, Completer: entryCompleter},
+	{Name: "xp", Help: "Copy password to clipboard", CmdFn: copyFieldCmd("Password"), Completer: entryCompleter},
+	{Name: "xu", Help: "Copy username to clipboard", CmdFn: copyFieldCmd("UserName"), Completer: entryCompleter},
 	{Name: "xx", Help: "Clear the clipboard", CmdFn: xxCmd},
 }
 
+func genCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	policy, err := parsePasswordPolicy(args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	password, bits, err := policy.generate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s  (%.1f bits)\n", password, bits)
+	return nil
+}
+
 func helpCmd(t *terminal.Term, ctx *terminal.Context) error {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	for _, cmd := range t.Cmds {
@@ -37,68 +88,504 @@ func helpCmd(t *terminal.Term, ctx *terminal.Context) error {
 	return w.Flush()
 }
 
+func confirm(t *terminal.Term, prompt string) (bool, error) {
+	answer, err := t.Line.Prompt(prompt + " [y/N] ")
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// parseFlags parses args with fs like fs.Parse, but also accepts flags
+// after the arguments, and returns the arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func confirmDiscard(t *terminal.Term) (bool, error) {
+	if !dirty {
+		return true, nil
+	}
+	return confirm(t, "The database has unsaved changes. Discard them?")
+}
+
+// newPasswordPrompt asks for a new master password twice. An empty
+// password has to be confirmed instead.
+func newPasswordPrompt(t *terminal.Term, prompt string) (string, error) {
+	password, err := t.Line.PasswordPrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		ok, err := confirm(t, "The password is empty. Use it anyway?")
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", errors.New("empty password")
+		}
+		return "", nil
+	}
+	return password, retypePassword(t, password)
+}
+
+func retypePassword(t *terminal.Term, password string) error {
+	retyped, err := t.Line.PasswordPrompt("Retype password: ")
+	if err != nil {
+		return err
+	}
+	if password != retyped {
+		return errors.New("passwords do not match")
+	}
+	return nil
+}
+
+// entryPasswordPrompt works like newPasswordPrompt, except that answering
+// with genToken generates the password instead.
+func entryPasswordPrompt(t *terminal.Term, prompt string) (string, error) {
+	password, err := t.Line.PasswordPrompt(prompt)
+	if err != nil || password == "" {
+		return "", err
+	}
+
+	args, err := shlex.Split(password)
+	if err != nil || len(args) == 0 || args[0] != genToken {
+		return password, retypePassword(t, password)
+	}
+	policy, err := parsePasswordPolicy(args[1:])
+	if err != nil {
+		return "", err
+	}
+	password, bits, err := policy.generate()
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Generated a password with %.1f bits of entropy\n", bits)
+	return password, nil
+}
+
 func exitCmd(t *terminal.Term, ctx *terminal.Context) error {
+	if ok, err := confirmDiscard(t); err != nil || !ok {
+		return err
+	}
 	t.Stop()
 	return errors.New("exit")
 }
 
+// promptCredentials asks for the password, unless noPassword is set, and
+// combines it with the key file at keyPath, if any.
+func promptCredentials(t *terminal.Term, prompt string, keyPath string, noPassword bool) (*gokeepasslib.DBCredentials, error) {
+	var password *string
+	if noPassword && keyPath == "" {
+		return nil, errors.New("a key file is needed without password")
+	}
+	if !noPassword {
+		p, err := t.Line.PasswordPrompt(prompt)
+		if err != nil {
+			return nil, err
+		}
+		password = &p
+	}
+	expandedKeyPath, err := homedir.Expand(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return newCredentials(password, expandedKeyPath)
+}
+
 func openCmd(t *terminal.Term, ctx *terminal.Context) error {
-	var filePath string
 	var keyPath string
-	var err error
-	var password string
+	var noPassword bool
 	args, err := shlex.Split(ctx.Args)
-	argsLength := len(args)
-
-	if argsLength == 0 {
-		return fmt.Errorf("args == 0")
+	if err != nil {
+		return err
 	}
-	if 0 < argsLength && argsLength <= 1 {
-		filePath = args[0]
-	} else {
-		filePath = args[0]
+	fs := flag.NewFlagSet("open", flag.ContinueOnError)
+	fs.StringVar(&keyPath, "keyfile", "", "key `file` of the database, in addition to the password")
+	fs.BoolVar(&noPassword, "no-password", false, "open with the key file only")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) == 2 && keyPath == "" {
 		keyPath = args[1]
+		args = args[:1]
 	}
+	if len(args) != 1 {
+		return errors.New("usage: open [--keyfile <file>] [--no-password] <file>")
+	}
+
+	if ok, err := confirmDiscard(t); err != nil || !ok {
+		return err
+	}
+	credentials, err := promptCredentials(t, "Enter password: ", keyPath, noPassword)
+	if err != nil {
+		return err
+	}
+	return openDatabase(t, args[0], credentials)
+}
+
+// openDatabase decodes the database at filePath and makes it the current one.
+func openDatabase(t *terminal.Term, filePath string, credentials *gokeepasslib.DBCredentials) error {
+	expandedFilePath, err := homedir.Expand(filePath)
+	if err != nil {
+		return err
+	}
+	db, err := decodeDatabase(expandedFilePath, credentials)
+	if err != nil {
+		return err
+	}
+	setDb(t, db, expandedFilePath)
+	if warning := masterKeyWarning(db, time.Now()); warning != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	return nil
+}
 
+// decodeOtherDatabase reads a database other than the opened one. Without
+// a key file it first tries the credentials of the opened database, as the
+// other one is usually a copy of it, and then asks for the password.
+func decodeOtherDatabase(t *terminal.Term, filePath string, keyPath string, noPassword bool) (*gokeepasslib.Database, error) {
 	expandedFilePath, err := homedir.Expand(filePath)
 	if err != nil {
+		return nil, err
+	}
+	if keyPath == "" && !noPassword && hasCredentials(db) {
+		if other, err := decodeDatabase(expandedFilePath, db.Credentials); err == nil {
+			return other, nil
+		}
+	}
+	credentials, err := promptCredentials(t, "Enter password of "+filePath+": ", keyPath, noPassword)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDatabase(expandedFilePath, credentials)
+}
+
+func closeCmd(t *terminal.Term, ctx *terminal.Context) error {
+	if ok, err := confirmDiscard(t); err != nil || !ok {
+		return err
+	}
+	setDb(t, newDatabase(), "")
+	return nil
+}
+
+func newCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var keyPath string
+	var noPassword bool
+	opts := databaseOptions{}
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	fs.StringVar(&opts.version, "version", "4", "KDBX `version`, 3.1 or 4")
+	fs.StringVar(&opts.cipher, "cipher", "aes", "outer `cipher`, aes or chacha20 (KDBX 4)")
+	fs.StringVar(&opts.kdf, "kdf", "", "key derivation `function`, aes or argon2d (KDBX 4); argon2d by default for KDBX 4")
+	fs.Uint64Var(&opts.rounds, "rounds", defaultRounds, "transformation rounds of AES-KDF")
+	fs.Uint64Var(&opts.memory, "memory", defaultMemory, "memory of Argon2 in `MiB`")
+	fs.Uint64Var(&opts.iterations, "iterations", defaultIterations, "iterations of Argon2")
+	var parallelism uint
+	fs.UintVar(&parallelism, "parallelism", defaultParallelism, "parallelism (threads) of Argon2")
+	fs.StringVar(&keyPath, "keyfile", "", "key `file` to protect the database with, in addition to the password")
+	fs.BoolVar(&noPassword, "no-password", false, "protect the database with the key file only")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: new [options] <file>")
+	}
+	if parallelism > 255 {
+		return errors.New("parallelism must be at most 255")
+	}
+	opts.parallelism = uint32(parallelism)
+	if opts.kdf == "" {
+		opts.kdf = "aes"
+		if strings.HasPrefix(opts.version, "4") {
+			opts.kdf = "argon2d"
+		}
+	}
+	if noPassword && keyPath == "" {
+		return errors.New("a key file is needed without password")
+	}
+
+	expandedFilePath, err := homedir.Expand(args[0])
+	if err != nil {
+		return err
+	}
+	d, err := createDatabase(opts, expandedFilePath)
+	if err != nil {
+		return err
+	}
+	if ok, err := confirmDiscard(t); err != nil || !ok {
+		return err
+	}
+	if _, err := os.Stat(expandedFilePath); err == nil {
+		if ok, err := confirm(t, fmt.Sprintf("%s already exists. Overwrite it?", args[0])); err != nil || !ok {
+			return err
+		}
+	}
+
+	var password *string
+	if !noPassword {
+		p, err := newPasswordPrompt(t, "Enter new password: ")
+		if err != nil {
+			return err
+		}
+		password = &p
+	}
+	expandedKeyPath, err := homedir.Expand(keyPath)
+	if err != nil {
+		return err
+	}
+	d.Credentials, err = newCredentials(password, expandedKeyPath)
+	if err != nil {
+		return err
+	}
+	if err := saveDatabase(d, expandedFilePath); err != nil {
 		return err
 	}
-	file, err := os.Open(expandedFilePath)
+	setDb(t, d, expandedFilePath)
+	return nil
+}
+
+// verifyCredentials asks for the password and the key file of the opened
+// database, whichever it has, and checks both.
+func verifyCredentials(t *terminal.Term) error {
+	if !hasCredentials(db) {
+		return nil
+	}
+	if db.Credentials.Passphrase != nil {
+		password, err := t.Line.PasswordPrompt("Current password: ")
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256([]byte(password))
+		if !sameKey(db.Credentials.Passphrase, hash[:]) {
+			return errors.New("wrong password")
+		}
+	}
+	if db.Credentials.Key != nil {
+		keyPath, err := t.Line.Prompt("Current key file: ")
+		if err != nil {
+			return err
+		}
+		expandedKeyPath, err := homedir.Expand(strings.TrimSpace(keyPath))
+		if err != nil {
+			return err
+		}
+		key, err := parseKeyFile(expandedKeyPath)
+		if err != nil {
+			return err
+		}
+		if !sameKey(db.Credentials.Key, key) {
+			return errors.New("wrong key file")
+		}
+	}
+	return nil
+}
+
+func passwdCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var keyPath string
+	var noPassword, keepPassword, removeKeyFile bool
+	args, err := shlex.Split(ctx.Args)
 	if err != nil {
 		return err
 	}
-	db := gokeepasslib.NewDatabase()
+	fs := flag.NewFlagSet("passwd", flag.ContinueOnError)
+	fs.StringVar(&keyPath, "keyfile", "", "new key `file`, replacing the current one if any")
+	fs.BoolVar(&removeKeyFile, "remove-keyfile", false, "remove the key file, keeping only the password")
+	fs.BoolVar(&noPassword, "no-password", false, "remove the password, keeping only the key file")
+	fs.BoolVar(&keepPassword, "keep-password", false, "keep the current password, changing only the key file")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) == 1 && keyPath == "" {
+		keyPath = args[0]
+		args = args[:0]
+	}
+	switch {
+	case len(args) != 0:
+		return errors.New("usage: passwd [options] [keyfile]")
+	case keyPath != "" && removeKeyFile:
+		return errors.New("-keyfile and -remove-keyfile cannot be used together")
+	case noPassword && keepPassword:
+		return errors.New("-no-password and -keep-password cannot be used together")
+	}
+	// passwd saves right away, and would save the other changes with it.
+	if dirty && dbPath != "" {
+		if ok, err := confirm(t, "The database has unsaved changes, which passwd would save too. Continue?"); err != nil || !ok {
+			return err
+		}
+	}
+
+	if err := verifyCredentials(t); err != nil {
+		return err
+	}
+
+	credentials := &gokeepasslib.DBCredentials{}
+	if hasCredentials(db) && !removeKeyFile {
+		credentials.Key = db.Credentials.Key
+	}
 	if keyPath != "" {
 		expandedKeyPath, err := homedir.Expand(keyPath)
 		if err != nil {
 			return err
 		}
-		db.Credentials, err = gokeepasslib.NewKeyCredentials(expandedKeyPath)
-		if err != nil {
+		if credentials.Key, err = parseKeyFile(expandedKeyPath); err != nil {
 			return err
 		}
-	} else {
-		password, err = t.Line.PasswordPrompt("Enter password: ")
+	}
+	switch {
+	case keepPassword:
+		if !hasCredentials(db) || db.Credentials.Passphrase == nil {
+			return errors.New("the database has no password to keep")
+		}
+		credentials.Passphrase = db.Credentials.Passphrase
+	case !noPassword:
+		password, err := newPasswordPrompt(t, "Enter new password: ")
 		if err != nil {
 			return err
 		}
-		db.Credentials = gokeepasslib.NewPasswordCredentials(password)
+		hash := sha256.Sum256([]byte(password))
+		credentials.Passphrase = hash[:]
+	}
+	if credentials.Passphrase == nil && credentials.Key == nil {
+		return errors.New("either a password or a key file is needed")
+	}
+
+	fileHeaders, innerHeader, err := rekeyHeader(db)
+	if err != nil {
+		return err
+	}
+	oldCredentials, oldFileHeaders, oldInnerHeader := db.Credentials, db.Header.FileHeaders, db.Content.InnerHeader
+	oldChanged := db.Content.Meta.MasterKeyChanged
+	now := timeNow(db)
+	db.Credentials = credentials
+	db.Header.FileHeaders = fileHeaders
+	db.Content.InnerHeader = innerHeader
+	db.Content.Meta.MasterKeyChanged = &now
+	if dbPath == "" {
+		dirty = true
+		return nil
+	}
+	if err := saveDatabase(db, dbPath); err != nil {
+		db.Credentials = oldCredentials
+		db.Header.FileHeaders = oldFileHeaders
+		db.Content.InnerHeader = oldInnerHeader
+		db.Content.Meta.MasterKeyChanged = oldChanged
+		return err
+	}
+	dirty = false
+	return nil
+}
+
+func keygenCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var binaryKey, askEntropy bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.BoolVar(&binaryKey, "binary", false, "write 32 raw bytes instead of an XML key file")
+	fs.BoolVar(&askEntropy, "entropy", false, "ask for random characters to mix into the key")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: keygen [-binary] [-entropy] <file>")
 	}
-	err = gokeepasslib.NewDecoder(file).Decode(db)
+
+	expandedFilePath, err := homedir.Expand(args[0])
 	if err != nil {
 		return err
 	}
-	if err := db.UnlockProtectedEntries(); err != nil {
+	if _, err := os.Stat(expandedFilePath); err == nil {
+		return fmt.Errorf("%s already exists", args[0])
+	}
+	var entropy []byte
+	if askEntropy {
+		text, err := t.Line.PasswordPrompt("Type random characters: ")
+		if err != nil {
+			return err
+		}
+		entropy = []byte(text)
+	}
+	key, err := generateKey(entropy)
+	if err != nil {
 		return err
 	}
-	setDb(t, db)
+	data := key
+	if !binaryKey {
+		data = xmlKeyFile(key)
+	}
+	return writeKeyFile(expandedFilePath, data)
+}
 
+func saveCmd(t *terminal.Term, ctx *terminal.Context) error {
+	if dbPath == "" {
+		return errors.New("no file name, use saveas")
+	}
+	if err := saveDatabase(db, dbPath); err != nil {
+		return err
+	}
+	dirty = false
 	return nil
 }
 
-func closeCmd(t *terminal.Term, ctx *terminal.Context) error {
-	setDb(t, newDatabase())
+func saveasCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("missing file name")
+	}
+
+	expandedFilePath, err := homedir.Expand(args[0])
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(expandedFilePath); err == nil {
+		if ok, err := confirm(t, fmt.Sprintf("%s already exists. Overwrite it?", args[0])); err != nil || !ok {
+			return err
+		}
+	}
+	if !hasCredentials(db) {
+		password, err := newPasswordPrompt(t, "Enter new password: ")
+		if err != nil {
+			return err
+		}
+		db.Credentials = gokeepasslib.NewPasswordCredentials(password)
+	}
+	if err := saveDatabase(db, expandedFilePath); err != nil {
+		return err
+	}
+	dbPath = expandedFilePath
+	dirty = false
 	return nil
 }
 
@@ -148,12 +635,23 @@ func calculateColumns(screenWidth int, items []string) (numColumns, numRows, max
 func lsCmd(t *terminal.Term, ctx *terminal.Context) error {
 	var items []string
 	target := cwd
-	if len(ctx.Args) > 0 {
-		var err error
-		target, err = travel(target, ctx.Args)
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		target, err = travel(target, args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	if outputFormat == jsonOutput {
+		listing, err := newListingJSON(target)
 		if err != nil {
 			return err
 		}
+		return printJSON(listing)
 	}
 
 	group := target.Group()
@@ -191,89 +689,1017 @@ func lsCmd(t *terminal.Term, ctx *terminal.Context) error {
 	return nil
 }
 
-func cdCmd(t *terminal.Term, ctx *terminal.Context) error {
-	if len(ctx.Args) > 0 {
-		wg, _ := shlex.Split(ctx.Args)
-		b, err := travel(cwd, wg[0])
-		if err != nil {
-			return err
+func addCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("missing entry title")
+	}
+
+	groupPath, title := groupSplit(args[0])
+	if title == "" {
+		return errors.New("missing entry title")
+	}
+	wg, err := travel(cwd, groupPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range wg.Group().Entries {
+		if entry.GetTitle() == title {
+			return errEntryExists
 		}
-		setCwd(t, b)
 	}
-	return nil
-}
 
-func xuCmd(t *terminal.Term, ctx *terminal.Context) error {
-	var selectedEntry *gokeepasslib.Entry
-	args, _ := shlex.Split(ctx.Args)
-	if len(args) > 0 {
-		if len(args) > 0 {
-			groupPath, entryTitle := groupSplit(args[0])
-			wg, err := travel(cwd, groupPath)
-			if err != nil {
-				return err
-			}
-			group := wg.Group()
-			for _, entry := range group.Entries {
-				title := entry.GetTitle()
-				if strings.TrimSpace(entryTitle) == title {
-					selectedEntry = &entry
-					break
-				}
+	username, err := t.Line.Prompt("Username: ")
+	if err != nil {
+		return err
+	}
+	password, err := entryPasswordPrompt(t, "Password ("+genToken+" to generate): ")
+	if err != nil {
+		return err
+	}
+	url, err := t.Line.Prompt("URL: ")
+	if err != nil {
+		return err
+	}
+	notes, err := t.Line.Prompt("Notes: ")
+	if err != nil {
+		return err
+	}
+
+	entry := newEntry(db)
+	entry.Values = append(entry.Values,
+		mkValue("Title", title),
+		mkValue("UserName", username),
+		mkProtectedValue("Password", password),
+		mkValue("URL", url),
+		mkValue("Notes", notes),
+	)
+	group := wg.Group()
+	group.Entries = append(group.Entries, entry)
+	dirty = true
+	return nil
+}
+
+func editCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: edit <entry> [field]")
+	}
+
+	wg, i, err := lookupEntry(args[0])
+	if err != nil {
+		return err
+	}
+	group := wg.Group()
+	entry := group.Entries[i]
+	fields := standardFields
+	if len(args) == 2 {
+		fields = args[1:]
+	}
+
+	var changed bool
+	edited := entry
+	edited.Values = append([]gokeepasslib.ValueData(nil), entry.Values...)
+	for _, field := range fields {
+		var value, newValue string
+		key, protected := field, strings.EqualFold(field, "Password")
+		v := getEntryValue(&edited, field)
+		if v != nil {
+			key, value, protected = v.Key, v.Value.Content, v.Value.Protected.Bool
+		}
+
+		if protected {
+			newValue, err = entryPasswordPrompt(t, key+" (empty to keep, "+genToken+" to generate): ")
+			if newValue == "" {
+				newValue = value
 			}
+		} else {
+			newValue, err = t.Line.PromptWithSuggestion(key+": ", value, -1)
+		}
+		if err != nil {
+			return err
+		}
+		if newValue == value {
+			continue
+		}
 
-			if selectedEntry != nil {
-				if err := clipboard.WriteAll(getEntryContent(*selectedEntry, "Username")); err != nil {
-					return err
+		if strings.EqualFold(key, "Title") {
+			if newValue == "" {
+				return errors.New("missing entry title")
+			}
+			for _, other := range group.Entries {
+				if other.GetTitle() == newValue {
+					return errEntryExists
 				}
 			}
 		}
+		if v != nil {
+			v.Value.Content = newValue
+		} else if protected {
+			edited.Values = append(edited.Values, mkProtectedValue(key, newValue))
+		} else {
+			edited.Values = append(edited.Values, mkValue(key, newValue))
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
 	}
+
+	pushHistory(db, &edited, entry)
+	modified := timeNow(db)
+	edited.Times.LastModificationTime = &modified
+	group.Entries[i] = edited
+	dirty = true
 	return nil
 }
 
-func xpCmd(t *terminal.Term, ctx *terminal.Context) error {
-	var selectedEntry *gokeepasslib.Entry
-	args, _ := shlex.Split(ctx.Args)
-	if len(args) > 0 {
-		if len(args) > 0 {
-			groupPath, entryTitle := groupSplit(args[0])
-			wg, err := travel(cwd, groupPath)
-			if err != nil {
-				return err
+func cdCmd(t *terminal.Term, ctx *terminal.Context) error {
+	if len(ctx.Args) > 0 {
+		wg, _ := shlex.Split(ctx.Args)
+		b, err := travel(cwd, wg[0])
+		if err != nil {
+			return err
+		}
+		setCwd(t, b)
+	}
+	return nil
+}
+
+// lookupEntry resolves path relative to cwd and returns the group holding the
+// entry together with the entry index in that group.
+func lookupEntry(path string) (workingGroup, int, error) {
+	groupPath, entryTitle := groupSplit(path)
+	wg, err := travel(cwd, groupPath)
+	if err != nil {
+		return nil, -1, err
+	}
+	entryTitle = strings.TrimSpace(entryTitle)
+	for i, entry := range wg.Group().Entries {
+		if entry.GetTitle() == entryTitle {
+			return wg, i, nil
+		}
+	}
+	return nil, -1, errNoSuchEntry
+}
+
+func rmCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("missing entry")
+	}
+
+	for _, arg := range args {
+		wg, i, err := lookupEntry(arg)
+		if err != nil {
+			return err
+		}
+		removeEntry(db, wg, i)
+		dirty = true
+		refreshCwd(t)
+	}
+	return nil
+}
+
+func rmdirCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var recursive bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "-r" {
+		recursive = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("missing group")
+	}
+
+	for _, arg := range args {
+		wg, err := travel(cwd, arg)
+		if err != nil {
+			return err
+		}
+		if wg.Prev() == nil {
+			return errors.New("cannot remove the root group")
+		}
+		group := wg.Group()
+		if !recursive && (len(group.Groups) > 0 || len(group.Entries) > 0) {
+			return errors.New("group not empty")
+		}
+		removeGroup(db, wg)
+		dirty = true
+		refreshCwd(t)
+	}
+	return nil
+}
+
+func mkdir(path string, parents bool) error {
+	wg := cwd
+	if strings.HasPrefix(path, "/") {
+		wg, _ = travel(cwd, "/")
+	}
+	parts := strings.Split(path, "/")
+	last := len(parts) - 1
+	for last >= 0 && parts[last] == "" {
+		last--
+	}
+	if last < 0 {
+		return errors.New("missing group name")
+	}
+
+	for i, part := range parts[:last+1] {
+		switch part {
+		case "", ".", "..":
+			if i == last {
+				return errGroupExists
 			}
-			group := wg.Group()
-			for _, entry := range group.Entries {
-				title := entry.GetTitle()
-				if strings.TrimSpace(entryTitle) == title {
-					selectedEntry = &entry
-					break
-				}
+			if part == ".." && wg.Prev() != nil {
+				wg = wg.Prev()
 			}
+			continue
+		}
 
-			if selectedEntry != nil {
-				if err := clipboard.WriteAll(selectedEntry.GetPassword()); err != nil {
-					return err
-				}
+		next, err := wg.ChGroup(part)
+		if err == nil {
+			if i == last && !parents {
+				return errGroupExists
+			}
+		} else if i == last || parents {
+			next, err = addGroup(db, wg, part)
+			if err == nil {
+				dirty = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+		wg = next
+	}
+	return nil
+}
+
+func mkdirCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var parents bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "-p" {
+		parents = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("missing group name")
+	}
+
+	for _, arg := range args {
+		err := mkdir(arg, parents)
+		refreshCwd(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mvDestination resolves the destination of mv. An existing group receives
+// the source under its current name, any other path names the source anew
+// inside its parent group.
+func mvDestination(path string, name string) (workingGroup, string, error) {
+	if wg, err := travel(cwd, path); err == nil {
+		return wg, name, nil
+	}
+	groupPath, newName := groupSplit(path)
+	wg, err := travel(cwd, groupPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return wg, strings.TrimSpace(newName), nil
+}
+
+func mvCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: mv <src> <dst>")
+	}
+
+	if src, i, err := lookupEntry(args[0]); err == nil {
+		dst, title, err := mvDestination(args[1], src.Group().Entries[i].GetTitle())
+		if err != nil {
+			return err
+		}
+		if err := moveEntry(db, src, i, dst, title); err != nil {
+			return err
+		}
+	} else {
+		src, err := travel(cwd, args[0])
+		if err != nil {
+			return err
+		}
+		dst, name, err := mvDestination(args[1], src.Group().Name)
+		if err != nil {
+			return err
+		}
+		if err := moveGroup(db, src, dst, name); err != nil {
+			return err
+		}
+	}
+	dirty = true
+	refreshCwd(t)
+	return nil
+}
+
+const (
+	maskedValue = "********"
+	timeLayout  = "2006-01-02 15:04:05"
+)
+
+func setCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(args) == 1 && (args[0] == "-e" || args[0] == "+e"):
+		errexit = args[0] == "-e"
+		return nil
+	case len(args) == 0:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, s := range settings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.name, s.get(), s.help)
+		}
+		return w.Flush()
+	case len(args) == 2:
+		s := findSetting(args[0])
+		if s == nil {
+			return errors.New("no such setting")
+		}
+		return s.set(args[1])
+	default:
+		return errors.New("usage: set [<name> <value> | -e | +e]")
+	}
+}
+
+func sourceCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: source <file>")
+	}
+	return sourceFile(t, args[0])
+}
+
+func showCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var full bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "-f" {
+		full = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("missing entry")
+	}
+
+	wg, i, err := lookupEntry(args[0])
+	if err != nil {
+		return err
+	}
+	entry := wg.Group().Entries[i]
+	if outputFormat == jsonOutput {
+		return printJSON(newEntryJSON(entryPath(wg, entry.GetTitle()), &entry, full))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	printField := func(name string, value string) {
+		for n, line := range strings.Split(value, "\n") {
+			if n > 0 {
+				name = ""
 			}
+			fmt.Fprintf(w, "%s\t%s\n", name, line)
+		}
+	}
+	printValue := func(name string, v *gokeepasslib.ValueData) {
+		if v == nil {
+			printField(name, "")
+		} else if v.Value.Protected.Bool && !full && v.Value.Content != "" {
+			printField(name, maskedValue)
+		} else {
+			printField(name, v.Value.Content)
+		}
+	}
+
+	for _, field := range standardFields {
+		printValue(field+":", getEntryValue(&entry, field))
+	}
+	for i := range entry.Values {
+		if !isStandardField(entry.Values[i].Key) {
+			printValue(entry.Values[i].Key+":", &entry.Values[i])
+		}
+	}
+	printField("Tags:", entry.Tags)
+	if entry.Times.Expires.Bool && entry.Times.ExpiryTime != nil {
+		printField("Expires:", entry.Times.ExpiryTime.Time.Local().Format(timeLayout))
+	} else {
+		printField("Expires:", "never")
+	}
+	if entry.Times.CreationTime != nil {
+		printField("Created:", entry.Times.CreationTime.Time.Local().Format(timeLayout))
+	}
+	if entry.Times.LastModificationTime != nil {
+		printField("Modified:", entry.Times.LastModificationTime.Time.Local().Format(timeLayout))
+	}
+	var attachments []string
+	for _, binary := range entry.Binaries {
+		attachments = append(attachments, binary.Name)
+	}
+	printField("Attachments:", strings.Join(attachments, "\n"))
+	return w.Flush()
+}
+
+// copyEntryField copies a field of the entry at path to the clipboard.
+func copyEntryField(path string, field string) error {
+	wg, i, err := lookupEntry(path)
+	if err != nil {
+		return err
+	}
+	entry := wg.Group().Entries[i]
+	v := getEntryValue(&entry, field)
+	if v == nil {
+		return errors.New("no such field")
+	}
+	return copyToClipboard(v.Value.Content)
+}
+
+func copyFieldCmd(field string) terminal.CommandFunc {
+	return func(t *terminal.Term, ctx *terminal.Context) error {
+		args, err := shlex.Split(ctx.Args)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errors.New("missing entry")
 		}
+		return copyEntryField(args[0], field)
+	}
+}
+
+func xfCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: xf <entry> <field>")
+	}
+	return copyEntryField(args[0], args[1])
+}
+
+// entryOTPCode returns the current one-time password of the entry at path
+// and how long it stays valid. HOTP entries move on to the next counter.
+func entryOTPCode(args string) (string, time.Duration, error) {
+	argv, err := shlex.Split(args)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(argv) == 0 {
+		return "", 0, errors.New("missing entry")
+	}
+	wg, i, err := lookupEntry(argv[0])
+	if err != nil {
+		return "", 0, err
+	}
+	group := wg.Group()
+	entry := group.Entries[i]
+	c, err := entryOTP(&entry)
+	if err != nil {
+		return "", 0, err
+	}
+	code, remaining := c.code(time.Now())
+	if c.hotp {
+		// Raising the counter is an edit, so that merge keeps it.
+		edited := entry
+		edited.Values = append([]gokeepasslib.ValueData(nil), entry.Values...)
+		advanceHOTP(&edited, c)
+		pushHistory(db, &edited, entry)
+		modified := timeNow(db)
+		edited.Times.LastModificationTime = &modified
+		group.Entries[i] = edited
+		dirty = true
+	}
+	return code, remaining, nil
+}
+
+func totpCmd(t *terminal.Term, ctx *terminal.Context) error {
+	code, remaining, err := entryOTPCode(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		fmt.Printf("%s  (%ds left)\n", code, remaining/time.Second)
+	} else {
+		fmt.Println(code)
 	}
 	return nil
 }
 
+func xoCmd(t *terminal.Term, ctx *terminal.Context) error {
+	code, _, err := entryOTPCode(ctx.Args)
+	if err != nil {
+		return err
+	}
+	return copyToClipboard(code)
+}
+
+func otpSetupCmd(t *terminal.Term, ctx *terminal.Context) error {
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	c := newOTPConfig()
+	var format string
+	fs := flag.NewFlagSet("otp-setup", flag.ContinueOnError)
+	fs.StringVar(&format, "format", "keepassxc", "storage format: keepassxc (otpauth URI) or keepass (TimeOtp-* fields)")
+	fs.StringVar(&c.algorithm, "algorithm", c.algorithm, "hash algorithm: SHA1, SHA256 or SHA512")
+	fs.IntVar(&c.digits, "digits", c.digits, "number of digits")
+	fs.IntVar(&c.period, "period", c.period, "TOTP period in seconds")
+	fs.BoolVar(&c.hotp, "hotp", false, "counter based HOTP instead of TOTP")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: otp-setup [options] <entry>")
+	}
+
+	wg, i, err := lookupEntry(args[0])
+	if err != nil {
+		return err
+	}
+	secret, err := t.Line.PasswordPrompt("Secret (base32 or otpauth:// URI): ")
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(secret, "otpauth://") {
+		c, err = parseOTPURI(secret)
+	} else {
+		c.secret, err = decodeBase32(secret)
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	group := wg.Group()
+	entry := group.Entries[i]
+	edited := entry
+	if err := setEntryOTP(&edited, c, format); err != nil {
+		return err
+	}
+	pushHistory(db, &edited, entry)
+	modified := timeNow(db)
+	edited.Times.LastModificationTime = &modified
+	group.Entries[i] = edited
+	dirty = true
+	return nil
+}
+
 func xxCmd(t *terminal.Term, ctx *terminal.Context) error {
+	copied.forget()
 	return clipboard.WriteAll("")
 }
 
 func findCmd(t *terminal.Term, ctx *terminal.Context) error {
-	var results []string
+	var results []entryMatch
 	root := newRootGroup(db)
 	args, _ := shlex.Split(ctx.Args)
 	if len(args) > 0 {
 		results = findEntry(root, args[0])
 	}
+	if outputFormat == jsonOutput {
+		entries := []entryJSON{}
+		for _, result := range results {
+			entries = append(entries, newEntryJSON(result.path, result.entry, false))
+		}
+		return printJSON(entries)
+	}
 	for _, result := range results {
-		fmt.Println(result)
+		fmt.Println(result.path)
+	}
+	return nil
+}
+
+func printTree(wg workingGroup, indent string) error {
+	group := wg.Group()
+	for _, subGroup := range group.Groups {
+		fmt.Printf("%s%s/\n", indent, subGroup.Name)
+		sub, err := wg.ChGroup(subGroup.Name)
+		if err != nil {
+			return err
+		}
+		if err := printTree(sub, indent+"  "); err != nil {
+			return err
+		}
+	}
+	for _, entry := range group.Entries {
+		fmt.Printf("%s%s\n", indent, entry.GetTitle())
+	}
+	return nil
+}
+
+func treeCmd(t *terminal.Term, ctx *terminal.Context) error {
+	target := cwd
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		target, err = travel(target, args[0])
+		if err != nil {
+			return err
+		}
+	}
+	if outputFormat == jsonOutput {
+		tree, err := newTreeJSON(target, false)
+		if err != nil {
+			return err
+		}
+		return printJSON(tree)
+	}
+	fmt.Println(target.String())
+	return printTree(target, "  ")
+}
+
+func kdfDescription(kdf kdfJSON) string {
+	description := kdf.Name
+	if kdf.Rounds > 0 {
+		description += fmt.Sprintf(", %d rounds", kdf.Rounds)
+	} else if kdf.Memory > 0 {
+		description += fmt.Sprintf(", %d KiB, %d iterations, %d threads", kdf.Memory/1024, kdf.Iterations, kdf.Parallelism)
+	}
+	return description
+}
+
+func infoCmd(t *terminal.Term, ctx *terminal.Context) error {
+	info := databaseInfo(db, dbPath)
+	if outputFormat == jsonOutput {
+		return printJSON(info)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Path:\t%s\n", info.Path)
+	fmt.Fprintf(w, "Name:\t%s\n", info.Name)
+	fmt.Fprintf(w, "Version:\tKDBX %s\n", info.Version)
+	fmt.Fprintf(w, "Cipher:\t%s\n", info.Cipher)
+	fmt.Fprintf(w, "KDF:\t%s\n", kdfDescription(info.KDF))
+	fmt.Fprintf(w, "Compression:\t%s\n", info.Compression)
+	fmt.Fprintf(w, "Groups:\t%d\n", info.Groups)
+	fmt.Fprintf(w, "Entries:\t%d\n", info.Entries)
+	if info.Modified {
+		fmt.Fprintf(w, "Unsaved changes:\tyes\n")
+	} else {
+		fmt.Fprintf(w, "Unsaved changes:\tno\n")
+	}
+	return w.Flush()
+}
+
+func kdfBenchCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var target time.Duration
+	var memory uint64
+	var parallelism uint
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("kdf-bench", flag.ContinueOnError)
+	fs.DurationVar(&target, "target", time.Second, "time the key derivation should take")
+	fs.Uint64Var(&memory, "memory", defaultMemory, "memory of Argon2 in `MiB`")
+	fs.UintVar(&parallelism, "parallelism", defaultParallelism, "parallelism (threads) of Argon2")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case len(args) != 0:
+		return errors.New("usage: kdf-bench [options]")
+	case target <= 0:
+		return errors.New("target must be positive")
+	case memory == 0:
+		return errors.New("memory must be positive")
+	case memory > maxMemory:
+		return fmt.Errorf("memory must be at most %d MiB", maxMemory)
+	case parallelism == 0 || parallelism > 255:
+		return errors.New("parallelism must be between 1 and 255")
+	}
+
+	rounds, err := benchmarkAESKDF(target)
+	if err != nil {
+		return err
+	}
+	iterations, err := benchmarkArgon2(target, memory, uint32(parallelism))
+	if err != nil {
+		return err
+	}
+	aesKDF := kdfJSON{Name: "AES-KDF", Rounds: rounds}
+	argon2d := kdfJSON{Name: "Argon2d", Memory: memory * 1024 * 1024, Iterations: iterations, Parallelism: uint32(parallelism)}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tkdf-set -kdf aes -rounds %d\n", kdfDescription(aesKDF), rounds)
+	fmt.Fprintf(w, "%s\tkdf-set -kdf argon2d -memory %d -iterations %d -parallelism %d\n", kdfDescription(argon2d), memory, iterations, parallelism)
+	return w.Flush()
+}
+
+func kdfSetCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var target time.Duration
+	opts := databaseOptions{
+		rounds:      defaultRounds,
+		memory:      defaultMemory,
+		iterations:  defaultIterations,
+		parallelism: defaultParallelism,
+	}
+	// Settings that are not given keep their current values.
+	current := kdfInfo(db.Header)
+	switch current.Name {
+	case "AES-KDF":
+		opts.kdf = "aes"
+		opts.rounds = current.Rounds
+	case "Argon2d":
+		opts.kdf = "argon2d"
+		opts.memory = current.Memory / 1024 / 1024
+		opts.iterations = current.Iterations
+		opts.parallelism = current.Parallelism
+	}
+
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("kdf-set", flag.ContinueOnError)
+	fs.StringVar(&opts.kdf, "kdf", opts.kdf, "key derivation `function`, aes or argon2d (KDBX 4)")
+	fs.Uint64Var(&opts.rounds, "rounds", opts.rounds, "transformation rounds of AES-KDF")
+	fs.Uint64Var(&opts.memory, "memory", opts.memory, "memory of Argon2 in `MiB`")
+	fs.Uint64Var(&opts.iterations, "iterations", opts.iterations, "iterations of Argon2")
+	parallelism := uint(opts.parallelism)
+	fs.UintVar(&parallelism, "parallelism", parallelism, "parallelism (threads) of Argon2")
+	fs.DurationVar(&target, "target", 0, "benchmark the rounds or iterations that take this `time`, unless given")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: kdf-set [options]")
+	}
+	if opts.kdf == "" {
+		return errors.New("unknown current kdf, use -kdf")
+	}
+	if parallelism > 255 {
+		return errors.New("parallelism must be at most 255")
+	}
+	opts.parallelism = uint32(parallelism)
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if target > 0 {
+		switch kdf := strings.ToLower(opts.kdf); {
+		case (kdf == "aes" || kdf == "aes-kdf") && !given["rounds"]:
+			opts.rounds, err = benchmarkAESKDF(target)
+		case (kdf == "argon2d" || kdf == "argon2") && !given["iterations"] && opts.memory > 0 && opts.parallelism > 0:
+			opts.iterations, err = benchmarkArgon2(target, opts.memory, opts.parallelism)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := setKdf(db, opts); err != nil {
+		return err
+	}
+	dirty = true
+	fmt.Printf("KDF: %s\n", kdfDescription(kdfInfo(db.Header)))
+	return nil
+}
+
+func exportCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var yes bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0] == "-y" {
+		yes = true
+		args = args[1:]
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: export [-y] <csv|json|xml> <file> [group]")
+	}
+
+	target := newRootGroup(db)
+	if len(args) == 3 {
+		target, err = travel(cwd, args[2])
+		if err != nil {
+			return err
+		}
+	}
+	expandedFilePath, err := homedir.Expand(args[1])
+	if err != nil {
+		return err
+	}
+	if !yes {
+		prompt := fmt.Sprintf("The export holds all passwords in plain text. Write it to %s?", args[1])
+		if _, err := os.Stat(expandedFilePath); err == nil {
+			prompt = fmt.Sprintf("The export holds all passwords in plain text. Overwrite %s with it?", args[1])
+		}
+		if ok, err := confirm(t, prompt); err != nil || !ok {
+			return err
+		}
+	}
+	return exportDatabase(db, target, args[0], expandedFilePath)
+}
+
+func importCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var format, columns string
+	var dryRun, yes, keepDuplicates bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.StringVar(&format, "format", "", "csv format: chrome, firefox, bitwarden, lastpass, keepassxc or custom (detected from the header by default)")
+	fs.StringVar(&columns, "columns", "", "comma separated column names of a custom csv file without header row")
+	fs.BoolVar(&dryRun, "n", false, "only show what would be imported")
+	fs.BoolVar(&yes, "y", false, "import without confirmation")
+	fs.BoolVar(&keepDuplicates, "keep-duplicates", false, "also import entries with the title, username and URL of an existing entry")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: import <csv|bitwarden|pass> <file> [group] [options]")
+	}
+
+	target := newRootGroup(db)
+	if len(args) == 3 {
+		if target, err = travel(cwd, args[2]); err != nil {
+			return err
+		}
+	}
+	path, err := homedir.Expand(args[1])
+	if err != nil {
+		return err
+	}
+	var items []importItem
+	switch args[0] {
+	case "csv":
+		var names []string
+		if columns != "" {
+			names = strings.Split(columns, ",")
+		}
+		items, err = readCSVFile(path, format, names)
+	case "bitwarden":
+		items, err = readBitwardenJSON(path)
+	case "pass":
+		items, err = readPassStore(path)
+	default:
+		return errors.New("unknown import source " + args[0] + ", must be csv, bitwarden or pass")
+	}
+	if err != nil {
+		return err
+	}
+
+	plan, err := planImport(db, target, items, keepDuplicates)
+	if err != nil {
+		return err
+	}
+	plan.printSummary(dryRun)
+	if dryRun || len(plan.items) == 0 {
+		return nil
+	}
+	if !yes {
+		if ok, err := confirm(t, "Import these entries?"); err != nil || !ok {
+			return err
+		}
+	}
+	changed, err := plan.apply(db)
+	if changed {
+		dirty = true
+		refreshCwd(t)
+	}
+	return err
+}
+
+func mergeCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var keyPath string
+	var noPassword bool
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.StringVar(&keyPath, "keyfile", "", "key `file` of the other database")
+	fs.BoolVar(&noPassword, "no-password", false, "open the other database with the key file only")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: merge [--keyfile <file>] [--no-password] <file>")
+	}
+
+	other, err := decodeOtherDatabase(t, args[0], keyPath, noPassword)
+	if err != nil {
+		return err
+	}
+	summary, err := mergeDatabase(db, other)
+	if summary != nil && summary.changed {
+		dirty = true
+	}
+	refreshCwd(t)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range []struct {
+		name  string
+		paths []string
+	}{
+		{"added", summary.added},
+		{"updated", summary.updated},
+		{"deleted", summary.deleted},
+		{"moved", summary.moved},
+	} {
+		for _, path := range change.paths {
+			fmt.Printf("%-8s %s\n", change.name, path)
+		}
+	}
+	fmt.Printf("%d added, %d updated, %d deleted, %d moved\n",
+		len(summary.added), len(summary.updated), len(summary.deleted), len(summary.moved))
+	return nil
+}
+
+func diffCmd(t *terminal.Term, ctx *terminal.Context) error {
+	var showSecrets, noPassword bool
+	var keyPath string
+	args, err := shlex.Split(ctx.Args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.BoolVar(&showSecrets, "show-secrets", false, "show the old and new protected values")
+	fs.StringVar(&keyPath, "keyfile", "", "key `file` of the other database")
+	fs.BoolVar(&noPassword, "no-password", false, "open the other database with the key file only")
+	args, err = parseFlags(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return errors.New("usage: diff [options] [file]")
+	}
+
+	var other *gokeepasslib.Database
+	if len(args) == 1 {
+		other, err = decodeOtherDatabase(t, args[0], keyPath, noPassword)
+	} else if dbPath == "" {
+		return errors.New("the database has not been saved yet")
+	} else {
+		other, err = decodeDatabase(dbPath, db.Credentials)
+	}
+	if err != nil {
+		return err
+	}
+	if len(other.Content.Root.Groups) == 0 || len(db.Content.Root.Groups) == 0 {
+		return errors.New("database without root group")
 	}
+	printChanges(os.Stdout, diffDatabases(other, db, showSecrets))
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"github.com/google/shlex"
 	"github.com/mitchellh/go-homedir"
 	"github.com/rogaps/kp2cli/terminal"
 )
@@ -184,3 +185,72 @@ func entryCompleter(line string, pos int) (head string, completions []string, ta
 	}
 	return
 }
+
+// fieldCompleter completes an entry as the first argument and the names of
+// the fields of that entry as the second one.
+func fieldCompleter(line string, pos int) (head string, completions []string, tail string) {
+	words := terminal.LineSplit(line[:pos])
+	wordslen := len(words)
+	if wordslen < 2 {
+		return entryCompleter(line, pos)
+	}
+	head = strings.Join(words[:wordslen-1], "")
+	tail = line[pos:]
+	if wordslen > 2 {
+		return
+	}
+
+	args, err := shlex.Split(words[0])
+	if err != nil || len(args) == 0 {
+		return
+	}
+	wg, i, err := lookupEntry(args[0])
+	if err != nil {
+		return
+	}
+	match := terminal.UnescapeString(words[1])
+	for _, v := range wg.Group().Entries[i].Values {
+		if strings.HasPrefix(v.Key, match) {
+			completions = append(completions, terminal.EscapeString(v.Key+" ", false))
+		}
+	}
+	return
+}
+
+func settingCompleter(line string, pos int) (head string, completions []string, tail string) {
+	words := terminal.LineSplit(line[:pos])
+	if len(words) != 1 {
+		return
+	}
+	tail = line[pos:]
+	for _, s := range settings {
+		if strings.HasPrefix(s.name, words[0]) {
+			completions = append(completions, s.name+" ")
+		}
+	}
+	return
+}
+
+func exportCompleter(line string, pos int) (head string, completions []string, tail string) {
+	words := terminal.LineSplit(line[:pos])
+	arg := len(words) - 1
+	if arg > 0 && strings.TrimSpace(words[0]) == "-y" {
+		arg--
+	}
+	switch arg {
+	case 0:
+		head = strings.Join(words[:len(words)-1], "")
+		tail = line[pos:]
+		for _, format := range exportFormats {
+			if strings.HasPrefix(format, words[len(words)-1]) {
+				completions = append(completions, format+" ")
+			}
+		}
+		return
+	case 1:
+		return filenameCompleter(line, pos)
+	case 2:
+		return groupCompleter(line, pos)
+	}
+	return line[:pos], nil, line[pos:]
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -18,6 +24,18 @@ func mkProtectedValue(key string, value string) gokeepasslib.ValueData {
 		Value: gokeepasslib.V{Content: value, Protected: w.NewBoolWrapper(true)},
 	}
 }
+func timeNow(d *gokeepasslib.Database) w.TimeWrapper {
+	return w.Now(w.WithFormatted(!d.Header.IsKdbx4()))
+}
+
+func newGroup(d *gokeepasslib.Database) gokeepasslib.Group {
+	return gokeepasslib.NewGroup(gokeepasslib.WithGroupFormattedTime(!d.Header.IsKdbx4()))
+}
+
+func newEntry(d *gokeepasslib.Database) gokeepasslib.Entry {
+	return gokeepasslib.NewEntry(gokeepasslib.WithEntryFormattedTime(!d.Header.IsKdbx4()))
+}
+
 func newDatabase() *gokeepasslib.Database {
 	rootGroup := gokeepasslib.NewGroup()
 	rootGroup.Name = "Database"
@@ -37,6 +55,188 @@ func newDatabase() *gokeepasslib.Database {
 	return db
 }
 
+func hasCredentials(d *gokeepasslib.Database) bool {
+	c := d.Credentials
+	return c != nil && (c.Passphrase != nil || c.Key != nil || c.Windows != nil)
+}
+
+// decodeDatabase reads the database at path and unlocks its protected
+// values.
+func decodeDatabase(path string, credentials *gokeepasslib.DBCredentials) (*gokeepasslib.Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d := gokeepasslib.NewDatabase()
+	d.Credentials = credentials
+	if err := gokeepasslib.NewDecoder(file).Decode(d); err != nil {
+		return nil, err
+	}
+	if err := d.UnlockProtectedEntries(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// saveDatabase encodes d into path. The file is written to a temporary file
+// next to path first and then renamed, so a failed write never leaves a
+// truncated database behind.
+func saveDatabase(d *gokeepasslib.Database, path string) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".kp2cli-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := d.LockProtectedEntries(); err != nil {
+		tmp.Close()
+		return err
+	}
+	err = gokeepasslib.NewEncoder(tmp).Encode(d)
+	if unlockErr := d.UnlockProtectedEntries(); err == nil {
+		err = unlockErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var standardFields = []string{"Title", "UserName", "Password", "URL", "Notes"}
+
+func isStandardField(key string) bool {
+	for _, field := range standardFields {
+		if strings.EqualFold(field, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func getEntryValue(entry *gokeepasslib.Entry, key string) *gokeepasslib.ValueData {
+	for i := range entry.Values {
+		if strings.EqualFold(entry.Values[i].Key, key) {
+			return &entry.Values[i]
+		}
+	}
+	return nil
+}
+
+func setEntryContent(entry *gokeepasslib.Entry, key string, value string) {
+	if v := getEntryValue(entry, key); v != nil {
+		v.Value.Content = value
+		return
+	}
+	entry.Values = append(entry.Values, mkValue(key, value))
+}
+
+// pushHistory stores old in the history of entry, dropping the oldest
+// versions beyond the HistoryMaxItems of the database.
+func pushHistory(d *gokeepasslib.Database, entry *gokeepasslib.Entry, old gokeepasslib.Entry) {
+	old.Histories = nil
+	if len(entry.Histories) == 0 {
+		entry.Histories = []gokeepasslib.History{{}}
+	}
+	history := &entry.Histories[0]
+	history.Entries = append(history.Entries, old)
+	if max := d.Content.Meta.HistoryMaxItems; max >= 0 && int64(len(history.Entries)) > max {
+		history.Entries = history.Entries[int64(len(history.Entries))-max:]
+	}
+}
+
+// addGroup creates an empty group named name inside wg and returns it.
+func addGroup(d *gokeepasslib.Database, wg workingGroup, name string) (workingGroup, error) {
+	group := newGroup(d)
+	group.Name = name
+	parent := wg.Group()
+	parent.Groups = append(parent.Groups, group)
+	return wg.ChGroup(name)
+}
+
+// moveEntry moves the entry at index i of src into dst under the given title.
+func moveEntry(d *gokeepasslib.Database, src workingGroup, i int, dst workingGroup, title string) error {
+	srcGroup, dstGroup := src.Group(), dst.Group()
+	sameGroup := srcGroup.UUID.Compare(dstGroup.UUID)
+	for j, entry := range dstGroup.Entries {
+		if entry.GetTitle() == title && !(sameGroup && j == i) {
+			return errEntryExists
+		}
+	}
+
+	now := timeNow(d)
+	entry := srcGroup.Entries[i]
+	if entry.GetTitle() != title {
+		setEntryContent(&entry, "Title", title)
+		entry.Times.LastModificationTime = &now
+	}
+	if sameGroup {
+		srcGroup.Entries[i] = entry
+		return nil
+	}
+
+	entry.Times.LocationChanged = &now
+	srcGroup.Entries = append(srcGroup.Entries[:i], srcGroup.Entries[i+1:]...)
+	dstGroup.Entries = append(dstGroup.Entries, entry)
+	return nil
+}
+
+// moveGroup moves the group src, with all its descendants, into dst under
+// the given name.
+func moveGroup(d *gokeepasslib.Database, src workingGroup, dst workingGroup, name string) error {
+	if src.Prev() == nil {
+		return errors.New("cannot move the root group")
+	}
+	group := *src.Group()
+	for wg := dst; wg != nil; wg = wg.Prev() {
+		if wg.Group().UUID.Compare(group.UUID) {
+			return errors.New("cannot move a group into itself")
+		}
+	}
+	parent, dstGroup := src.Prev().Group(), dst.Group()
+	for _, subGroup := range dstGroup.Groups {
+		if subGroup.Name == name && !subGroup.UUID.Compare(group.UUID) {
+			return errGroupExists
+		}
+	}
+
+	now := timeNow(d)
+	if group.Name != name {
+		group.Name = name
+		group.Times.LastModificationTime = &now
+	}
+	if parent.UUID.Compare(dstGroup.UUID) {
+		*src.Group() = group
+		return nil
+	}
+
+	group.Times.LocationChanged = &now
+	dstUUID := dstGroup.UUID
+	for i := range parent.Groups {
+		if parent.Groups[i].UUID.Compare(group.UUID) {
+			parent.Groups = append(parent.Groups[:i], parent.Groups[i+1:]...)
+			break
+		}
+	}
+	// Removing src may have shifted dst within its parent, so the pointer may
+	// now be another group. Look it up again by the UUID saved before.
+	dstGroup = findGroupByUUID(&d.Content.Root.Groups[0], dstUUID)
+	dstGroup.Groups = append(dstGroup.Groups, group)
+	return nil
+}
+
 func getEntryContent(entry gokeepasslib.Entry, key string) string {
 	for i := range entry.Values {
 		if strings.EqualFold(entry.Values[i].Key, key) {
@@ -46,14 +246,28 @@ func getEntryContent(entry gokeepasslib.Entry, key string) string {
 	return ""
 }
 
-func findEntry(root workingGroup, search string) []string {
-	var results []string
+// entryPath joins the path of a group and the title of one of its entries.
+func entryPath(wg workingGroup, title string) string {
+	dir := wg.String()
+	if strings.HasSuffix(dir, "/") {
+		return dir + title
+	}
+	return dir + "/" + title
+}
+
+type entryMatch struct {
+	path  string
+	entry *gokeepasslib.Entry
+}
+
+func findEntry(root workingGroup, search string) []entryMatch {
+	var results []entryMatch
 	group := root.Group()
 	regex := regexp.MustCompile("(?i)" + search)
-	for _, entry := range group.Entries {
+	for i, entry := range group.Entries {
 		for _, value := range entry.Values {
 			if regex.MatchString(value.Value.Content) {
-				results = append(results, strings.Join([]string{root.String(), entry.GetTitle()}, "/"))
+				results = append(results, entryMatch{entryPath(root, entry.GetTitle()), &group.Entries[i]})
 				break
 			}
 		}
@@ -67,3 +281,87 @@ func findEntry(root workingGroup, search string) []string {
 	}
 	return results
 }
+
+// walkEntries calls fn for every entry in wg and its subgroups, groups
+// before entries as in the tree.
+func walkEntries(wg workingGroup, fn func(wg workingGroup, entry *gokeepasslib.Entry) error) error {
+	group := wg.Group()
+	for _, subGroup := range group.Groups {
+		sub, err := wg.ChGroup(subGroup.Name)
+		if err != nil {
+			return err
+		}
+		if err := walkEntries(sub, fn); err != nil {
+			return err
+		}
+	}
+	for i := range group.Entries {
+		if err := fn(wg, &group.Entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cipherName(id []byte) string {
+	switch {
+	case bytes.Equal(id, gokeepasslib.CipherAES):
+		return "AES-256"
+	case bytes.Equal(id, gokeepasslib.CipherChaCha20):
+		return "ChaCha20"
+	case bytes.Equal(id, gokeepasslib.CipherTwoFish):
+		return "Twofish"
+	}
+	return fmt.Sprintf("unknown (%x)", id)
+}
+
+func kdfInfo(h *gokeepasslib.DBHeader) kdfJSON {
+	if !h.IsKdbx4() {
+		return kdfJSON{Name: "AES-KDF", Rounds: h.FileHeaders.TransformRounds}
+	}
+	k := h.FileHeaders.KdfParameters
+	switch {
+	case k == nil:
+		return kdfJSON{Name: "unknown"}
+	case bytes.Equal(k.UUID, gokeepasslib.KdfArgon2):
+		return kdfJSON{Name: "Argon2d", Memory: k.Memory, Iterations: k.Iterations, Parallelism: k.Parallelism}
+	case bytes.Equal(k.UUID, kdfArgon2id):
+		return kdfJSON{Name: "Argon2id", Memory: k.Memory, Iterations: k.Iterations, Parallelism: k.Parallelism}
+	case bytes.Equal(k.UUID, gokeepasslib.KdfAES4), bytes.Equal(k.UUID, gokeepasslib.KdfAES3):
+		return kdfJSON{Name: "AES-KDF", Rounds: k.Rounds}
+	}
+	return kdfJSON{Name: fmt.Sprintf("unknown (%x)", k.UUID)}
+}
+
+// countItems returns the number of groups below and entries in group.
+func countItems(group *gokeepasslib.Group) (groups int, entries int) {
+	entries = len(group.Entries)
+	for i := range group.Groups {
+		g, e := countItems(&group.Groups[i])
+		groups += g + 1
+		entries += e
+	}
+	return
+}
+
+func databaseInfo(d *gokeepasslib.Database, path string) infoJSON {
+	h := d.Header
+	info := infoJSON{
+		Path:        path,
+		Name:        d.Content.Meta.DatabaseName,
+		Version:     fmt.Sprintf("%d.%d", h.Signature.MajorVersion, h.Signature.MinorVersion),
+		Cipher:      cipherName(h.FileHeaders.CipherID),
+		KDF:         kdfInfo(h),
+		Compression: "none",
+		Modified:    dirty,
+	}
+	if h.FileHeaders.CompressionFlags == gokeepasslib.GzipCompressionFlag {
+		info.Compression = "gzip"
+	}
+	for i := range d.Content.Root.Groups {
+		g, e := countItems(&d.Content.Root.Groups[i])
+		info.Groups += g + 1
+		info.Entries += e
+	}
+	return info
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// groupTree returns the names of the groups below group, like "A(B) C".
+func groupTree(group *gokeepasslib.Group) string {
+	var names []string
+	for i := range group.Groups {
+		name := group.Groups[i].Name
+		if len(group.Groups[i].Groups) > 0 {
+			name += "(" + groupTree(&group.Groups[i]) + ")"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestMoveGroup(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		want     string
+	}{
+		{"A", "B", "B(A) C"},
+		{"A", "C", "B C(A)"},
+		{"C", "A", "A(C) B"},
+		{"B", "C", "A C(B)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src+" into "+tt.dst, func(t *testing.T) {
+			d, err := createDatabase(databaseOptions{version: "4", cipher: "aes", kdf: "aes", rounds: 1}, "test.kdbx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Content.Root.Groups[0].Groups = []gokeepasslib.Group{
+				testGroup("A", testGroupA),
+				testGroup("B", testGroupB),
+				testGroup("C", testGroupC),
+			}
+			root := newRootGroup(d)
+			src, err := root.ChGroup(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst, err := root.ChGroup(tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := moveGroup(d, src, dst, tt.src); err != nil {
+				t.Fatal(err)
+			}
+			if got := groupTree(&d.Content.Root.Groups[0]); got != tt.want {
+				t.Errorf("groups = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// objectChange is a difference found by diffDatabases for one group or
+// entry.
+type objectChange struct {
+	kind    byte // '+' added, '-' removed, '~' modified, '>' moved
+	path    string
+	oldPath string
+	fields  []string
+}
+
+// parentUUID identifies the parent of ref, using the zero UUID for the root
+// group so that databases with different roots compare equal.
+func parentUUID(ref objectRef, root *gokeepasslib.Group) gokeepasslib.UUID {
+	if ref.parent == nil || ref.parent == root {
+		return gokeepasslib.UUID{}
+	}
+	return ref.parent.UUID
+}
+
+func diffValue(name string, old string, new string, secret bool, showSecrets bool) string {
+	switch {
+	case secret && !showSecrets:
+		return name + ": changed"
+	case old == "":
+		return name + ": added " + strconv.Quote(new)
+	case new == "":
+		return name + ": removed " + strconv.Quote(old)
+	}
+	return name + ": " + strconv.Quote(old) + " -> " + strconv.Quote(new)
+}
+
+// fieldKeys returns the keys of the string fields of both entries, the
+// standard fields first.
+func fieldKeys(old *gokeepasslib.Entry, new *gokeepasslib.Entry) []string {
+	keys := append([]string(nil), standardFields...)
+	var custom []string
+	seen := map[string]bool{}
+	for _, entry := range []*gokeepasslib.Entry{old, new} {
+		for _, v := range entry.Values {
+			if !isStandardField(v.Key) && !seen[v.Key] {
+				seen[v.Key] = true
+				custom = append(custom, v.Key)
+			}
+		}
+	}
+	sort.Strings(custom)
+	return append(keys, custom...)
+}
+
+func attachments(d *gokeepasslib.Database, entry *gokeepasslib.Entry) map[string][]byte {
+	result := map[string][]byte{}
+	for i := range entry.Binaries {
+		var content []byte
+		if binary := entry.Binaries[i].Find(d); binary != nil {
+			content, _ = binaryContent(d, binary)
+		}
+		result[entry.Binaries[i].Name] = content
+	}
+	return result
+}
+
+func expiry(times gokeepasslib.TimeData) string {
+	if !times.Expires.Bool || times.ExpiryTime == nil {
+		return ""
+	}
+	return times.ExpiryTime.Time.Local().Format(timeLayout)
+}
+
+// diffEntries describes the changes from old, of the database oldDb, to new.
+func diffEntries(oldDb *gokeepasslib.Database, old *gokeepasslib.Entry, newDb *gokeepasslib.Database, new *gokeepasslib.Entry, showSecrets bool) []string {
+	var changes []string
+	for _, key := range fieldKeys(old, new) {
+		oldValue, newValue := getEntryValue(old, key), getEntryValue(new, key)
+		var oldContent, newContent string
+		secret := false
+		if oldValue != nil {
+			oldContent = oldValue.Value.Content
+			secret = oldValue.Value.Protected.Bool
+		}
+		if newValue != nil {
+			newContent = newValue.Value.Content
+			secret = secret || newValue.Value.Protected.Bool
+		}
+		if oldContent != newContent {
+			changes = append(changes, diffValue(key, oldContent, newContent, secret, showSecrets))
+		}
+	}
+	if old.Tags != new.Tags {
+		changes = append(changes, diffValue("Tags", old.Tags, new.Tags, false, showSecrets))
+	}
+	if oldExpiry, newExpiry := expiry(old.Times), expiry(new.Times); oldExpiry != newExpiry {
+		changes = append(changes, diffValue("Expires", oldExpiry, newExpiry, false, showSecrets))
+	}
+
+	oldAttachments, newAttachments := attachments(oldDb, old), attachments(newDb, new)
+	var names []string
+	for name := range oldAttachments {
+		names = append(names, name)
+	}
+	for name := range newAttachments {
+		if _, ok := oldAttachments[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oldContent, inOld := oldAttachments[name]
+		newContent, inNew := newAttachments[name]
+		switch {
+		case !inOld:
+			changes = append(changes, "Attachment: added "+strconv.Quote(name))
+		case !inNew:
+			changes = append(changes, "Attachment: removed "+strconv.Quote(name))
+		case !bytes.Equal(oldContent, newContent):
+			changes = append(changes, "Attachment: changed "+strconv.Quote(name))
+		}
+	}
+	return changes
+}
+
+func diffGroups(old *gokeepasslib.Group, new *gokeepasslib.Group) []string {
+	var changes []string
+	if old.Name != new.Name {
+		changes = append(changes, diffValue("Name", old.Name, new.Name, false, false))
+	}
+	if old.Notes != new.Notes {
+		changes = append(changes, diffValue("Notes", old.Notes, new.Notes, false, false))
+	}
+	return changes
+}
+
+func objectPath(ref objectRef) string {
+	if ref.group != nil {
+		return strings.TrimSuffix(ref.path, "/") + "/"
+	}
+	return ref.path
+}
+
+// diffDatabases lists the groups and entries added, removed, moved or
+// modified from oldDb to newDb, matching them by UUID. Protected values are
+// only shown when showSecrets is set.
+func diffDatabases(oldDb *gokeepasslib.Database, newDb *gokeepasslib.Database, showSecrets bool) []objectChange {
+	oldRoot, newRoot := &oldDb.Content.Root.Groups[0], &newDb.Content.Root.Groups[0]
+	oldIndex, newIndex := indexObjects(oldRoot), indexObjects(newRoot)
+	delete(oldIndex, oldRoot.UUID)
+	delete(newIndex, newRoot.UUID)
+
+	var changes []objectChange
+	for uuid, newRef := range newIndex {
+		oldRef, ok := oldIndex[uuid]
+		if !ok || (oldRef.group == nil) != (newRef.group == nil) {
+			changes = append(changes, objectChange{kind: '+', path: objectPath(newRef)})
+			continue
+		}
+		if parentUUID(oldRef, oldRoot) != parentUUID(newRef, newRoot) {
+			changes = append(changes, objectChange{kind: '>', path: objectPath(newRef), oldPath: objectPath(oldRef)})
+		}
+		var fields []string
+		if newRef.group != nil {
+			fields = diffGroups(oldRef.group, newRef.group)
+		} else {
+			fields = diffEntries(oldDb, oldRef.entry, newDb, newRef.entry, showSecrets)
+		}
+		if len(fields) > 0 {
+			changes = append(changes, objectChange{kind: '~', path: objectPath(newRef), fields: fields})
+		}
+	}
+	for uuid, oldRef := range oldIndex {
+		if newRef, ok := newIndex[uuid]; !ok || (oldRef.group == nil) != (newRef.group == nil) {
+			changes = append(changes, objectChange{kind: '-', path: objectPath(oldRef)})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].path != changes[j].path {
+			return changes[i].path < changes[j].path
+		}
+		return changes[i].kind < changes[j].kind
+	})
+	return changes
+}
+
+func printChanges(w io.Writer, changes []objectChange) {
+	for _, change := range changes {
+		switch change.kind {
+		case '>':
+			fmt.Fprintf(w, "> %s -> %s\n", change.oldPath, change.path)
+		default:
+			fmt.Fprintf(w, "%c %s\n", change.kind, change.path)
+		}
+		for _, field := range change.fields {
+			fmt.Fprintf(w, "    %s\n", field)
+		}
+	}
+}
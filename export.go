@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+var exportFormats = []string{"csv", "json", "xml"}
+
+// csvHeader is the column layout of KeePassXC's CSV export, which KeePass
+// and most password managers can import.
+var csvHeader = []string{"Group", "Title", "Username", "Password", "URL", "Notes", "TOTP", "Icon", "Last Modified", "Created"}
+
+// csvGroup names wg the way KeePassXC does, starting with the name of the
+// root group.
+func csvGroup(d *gokeepasslib.Database, wg workingGroup) string {
+	var root string
+	if len(d.Content.Root.Groups) > 0 {
+		root = d.Content.Root.Groups[0].Name
+	}
+	return path.Join(root, wg.String())
+}
+
+// csvTime formats t like KeePassXC, in UTC.
+func csvTime(t *w.TimeWrapper) string {
+	if t == nil {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
+
+// entryTOTP returns the one-time password settings of entry as an otpauth
+// URI, or "" when it has none.
+func entryTOTP(entry *gokeepasslib.Entry) string {
+	if v := getEntryValue(entry, otpField); v != nil && v.Value.Content != "" {
+		return v.Value.Content
+	}
+	c, err := entryOTP(entry)
+	if err != nil {
+		return ""
+	}
+	return c.uri(entry.GetTitle(), getEntryContent(*entry, "UserName"))
+}
+
+func exportCSV(w io.Writer, d *gokeepasslib.Database, wg workingGroup) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	err := walkEntries(wg, func(wg workingGroup, entry *gokeepasslib.Entry) error {
+		return cw.Write([]string{
+			csvGroup(d, wg),
+			entry.GetTitle(),
+			getEntryContent(*entry, "UserName"),
+			entry.GetPassword(),
+			getEntryContent(*entry, "URL"),
+			getEntryContent(*entry, "Notes"),
+			entryTOTP(entry),
+			strconv.FormatInt(entry.IconID, 10),
+			csvTime(entry.Times.LastModificationTime),
+			csvTime(entry.Times.CreationTime),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func exportJSON(w io.Writer, wg workingGroup) error {
+	tree, err := newTreeJSON(wg, true)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tree)
+}
+
+// plainEntry returns a copy of entry, and of its history, with protected
+// values marked as unprotected. KeePass reads protected values of an XML
+// file as encrypted by the inner random stream.
+func plainEntry(entry gokeepasslib.Entry) gokeepasslib.Entry {
+	values := make([]gokeepasslib.ValueData, len(entry.Values))
+	for i, v := range entry.Values {
+		if v.Value.Protected.Bool {
+			v.Value.Protected = w.NewBoolWrapper(false)
+		}
+		values[i] = v
+	}
+	entry.Values = values
+
+	histories := make([]gokeepasslib.History, len(entry.Histories))
+	for i, history := range entry.Histories {
+		histories[i].Entries = make([]gokeepasslib.Entry, len(history.Entries))
+		for j, old := range history.Entries {
+			histories[i].Entries[j] = plainEntry(old)
+		}
+	}
+	entry.Histories = histories
+	return entry
+}
+
+func plainGroups(groups []gokeepasslib.Group) []gokeepasslib.Group {
+	copied := make([]gokeepasslib.Group, len(groups))
+	for i, group := range groups {
+		entries := make([]gokeepasslib.Entry, len(group.Entries))
+		for j, entry := range group.Entries {
+			entries[j] = plainEntry(entry)
+		}
+		group.Entries = entries
+		group.Groups = plainGroups(group.Groups)
+		copied[i] = group
+	}
+	return copied
+}
+
+// xmlBinaries returns the attachments of d the way KeePass 2.x XML stores
+// them in Meta. KDBX 4 keeps them raw in the inner header instead.
+func xmlBinaries(d *gokeepasslib.Database) (gokeepasslib.Binaries, error) {
+	if !d.Header.IsKdbx4() {
+		return d.Content.Meta.Binaries, nil
+	}
+	var binaries gokeepasslib.Binaries
+	for _, b := range *databaseBinaries(d) {
+		binary := gokeepasslib.Binary{ID: b.ID, Compressed: w.NewBoolWrapper(true)}
+		if err := binary.SetContent(b.Content); err != nil {
+			return nil, err
+		}
+		binaries = append(binaries, binary)
+	}
+	return binaries, nil
+}
+
+// exportXML writes the database content as KeePass 2.x XML, with protected
+// values in plain text. Exporting a subtree leaves out the other groups and
+// the deleted objects.
+func exportXML(w io.Writer, d *gokeepasslib.Database, wg workingGroup) error {
+	content := *d.Content
+	root := *d.Content.Root
+	if _, ok := wg.(*rootGroup); !ok {
+		root = gokeepasslib.RootData{Groups: []gokeepasslib.Group{*wg.Group()}}
+	}
+	root.Groups = plainGroups(root.Groups)
+	content.Root = &root
+
+	meta := *d.Content.Meta
+	binaries, err := xmlBinaries(d)
+	if err != nil {
+		return err
+	}
+	meta.Binaries = binaries
+	content.Meta = &meta
+
+	data, err := xml.MarshalIndent(content, "", "\t")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// exportDatabase writes the entries of wg in format to path. The file is
+// only readable by its owner, even when it already existed, as it holds the
+// passwords in plain text.
+func exportDatabase(d *gokeepasslib.Database, wg workingGroup, format string, path string) error {
+	var export func(w io.Writer) error
+	switch format {
+	case "csv":
+		export = func(w io.Writer) error { return exportCSV(w, d, wg) }
+	case "json":
+		export = func(w io.Writer) error { return exportJSON(w, wg) }
+	case "xml":
+		export = func(w io.Writer) error { return exportXML(w, d, wg) }
+	default:
+		return errors.New("unknown format " + format + ", must be csv, json or xml")
+	}
+
+	// Like saveDatabase, write a temporary file and rename it, so that an
+	// existing file does not keep a more open mode.
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".kp2cli-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = tmp.Chmod(0600)
+	if err == nil {
+		err = export(tmp)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
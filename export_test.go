@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+func TestExportXMLDecodes(t *testing.T) {
+	for _, version := range []string{"3.1", "4"} {
+		t.Run(version, func(t *testing.T) {
+			d, err := createDatabase(databaseOptions{version: version, cipher: "aes", kdf: "aes", rounds: 1}, "test.kdbx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := addBinary(d, []byte("attached"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := newEntry(d)
+			old.Values = []gokeepasslib.ValueData{mkValue("Title", "GitHub"), mkProtectedValue("Password", "oldpw")}
+			entry := newEntry(d)
+			entry.Values = []gokeepasslib.ValueData{mkValue("Title", "GitHub"), mkProtectedValue("Password", "ghpw")}
+			ref := gokeepasslib.BinaryReference{Name: "notes.txt"}
+			ref.Value.ID = id
+			entry.Binaries = []gokeepasslib.BinaryReference{ref}
+			entry.Histories = []gokeepasslib.History{{Entries: []gokeepasslib.Entry{old}}}
+			d.Content.Root.Groups[0].Entries = []gokeepasslib.Entry{entry}
+
+			var buf bytes.Buffer
+			if err := exportXML(&buf, d, newRootGroup(d)); err != nil {
+				t.Fatal(err)
+			}
+			var content gokeepasslib.DBContent
+			if err := xml.Unmarshal(buf.Bytes(), &content); err != nil {
+				t.Fatal(err)
+			}
+
+			got := content.Root.Groups[0].Entries[0]
+			password := getEntryValue(&got, "Password")
+			if password == nil || password.Value.Content != "ghpw" || password.Value.Protected.Bool {
+				t.Errorf("password = %+v, want unprotected ghpw", password)
+			}
+			oldPassword := getEntryValue(&got.Histories[0].Entries[0], "Password")
+			if oldPassword == nil || oldPassword.Value.Content != "oldpw" || oldPassword.Value.Protected.Bool {
+				t.Errorf("history password = %+v, want unprotected oldpw", oldPassword)
+			}
+			if !getEntryValue(&entry, "Password").Value.Protected.Bool {
+				t.Error("export changed the protection of the database entry")
+			}
+
+			binary := content.Meta.Binaries.Find(got.Binaries[0].Value.ID)
+			if binary == nil {
+				t.Fatal("attachment reference does not resolve")
+			}
+			// Meta binaries are stored like in KDBX 3.1.
+			data, err := binaryContent(gokeepasslib.NewDatabase(), binary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "attached" {
+				t.Errorf("attachment = %q, want %q", data, "attached")
+			}
+		})
+	}
+}
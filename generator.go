@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+const (
+	upperChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerChars   = "abcdefghijklmnopqrstuvwxyz"
+	digitChars   = "0123456789"
+	symbolChars  = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+	similarChars = "0O1Il|"
+)
+
+// genToken answers a password prompt of add or edit with a generated
+// password. It may be followed by the options of the gen command.
+const genToken = "!gen"
+
+type passwordPolicy struct {
+	length         int
+	classes        string
+	charset        string
+	excludeSimilar bool
+	requireEach    bool
+
+	words        int
+	separator    string
+	capitalize   bool
+	injectDigit  bool
+	injectSymbol bool
+	wordlist     string
+}
+
+func parsePasswordPolicy(args []string) (*passwordPolicy, error) {
+	p := &passwordPolicy{}
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	fs.IntVar(&p.length, "l", 20, "password length")
+	fs.StringVar(&p.classes, "c", "ulds", "character classes: u(pper), l(ower), d(igits), s(ymbols)")
+	fs.StringVar(&p.charset, "s", "", "custom character set, overrides -c")
+	fs.BoolVar(&p.excludeSimilar, "x", false, "exclude look-alike characters ("+similarChars+")")
+	fs.BoolVar(&p.requireEach, "r", false, "require at least one character of each class")
+	fs.IntVar(&p.words, "w", 0, "generate a passphrase of this many words instead")
+	fs.StringVar(&p.separator, "sep", " ", "passphrase word separator")
+	fs.BoolVar(&p.capitalize, "caps", false, "capitalize passphrase words")
+	fs.BoolVar(&p.injectDigit, "digit", false, "add a random digit to a random passphrase word")
+	fs.BoolVar(&p.injectSymbol, "symbol", false, "add a random symbol to a random passphrase word")
+	fs.StringVar(&p.wordlist, "wordlist", "", "passphrase wordlist file instead of the EFF large wordlist")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, errors.New("unexpected argument: " + fs.Arg(0))
+	}
+	return p, nil
+}
+
+// charClasses returns the character sets the password is drawn from, without
+// duplicated characters.
+func (p *passwordPolicy) charClasses() ([]string, error) {
+	var classes []string
+	if p.charset != "" {
+		classes = append(classes, p.charset)
+	} else {
+		for _, c := range p.classes {
+			switch c {
+			case 'u':
+				classes = append(classes, upperChars)
+			case 'l':
+				classes = append(classes, lowerChars)
+			case 'd':
+				classes = append(classes, digitChars)
+			case 's':
+				classes = append(classes, symbolChars)
+			default:
+				return nil, errors.New("unknown character class: " + string(c))
+			}
+		}
+	}
+
+	seen := make(map[rune]bool)
+	var result []string
+	for _, class := range classes {
+		var b strings.Builder
+		for _, r := range class {
+			if seen[r] || (p.excludeSimilar && strings.ContainsRune(similarChars, r)) {
+				continue
+			}
+			seen[r] = true
+			b.WriteRune(r)
+		}
+		if b.Len() > 0 {
+			result = append(result, b.String())
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("empty character set")
+	}
+	return result, nil
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+func randomRune(set []rune) (rune, error) {
+	i, err := randomInt(len(set))
+	if err != nil {
+		return 0, err
+	}
+	return set[i], nil
+}
+
+// generate returns a new password or passphrase following p and its
+// estimated entropy in bits.
+func (p *passwordPolicy) generate() (string, float64, error) {
+	if p.words > 0 {
+		return p.generatePassphrase()
+	}
+	return p.generatePassword()
+}
+
+func (p *passwordPolicy) generatePassword() (string, float64, error) {
+	classes, err := p.charClasses()
+	if err != nil {
+		return "", 0, err
+	}
+	if p.length <= 0 {
+		return "", 0, errors.New("length must be positive")
+	}
+	if p.requireEach && p.length < len(classes) {
+		return "", 0, errors.New("length is shorter than the number of character classes")
+	}
+
+	pool := []rune(strings.Join(classes, ""))
+	password := make([]rune, 0, p.length)
+	if p.requireEach {
+		for _, class := range classes {
+			r, err := randomRune([]rune(class))
+			if err != nil {
+				return "", 0, err
+			}
+			password = append(password, r)
+		}
+	}
+	for len(password) < p.length {
+		r, err := randomRune(pool)
+		if err != nil {
+			return "", 0, err
+		}
+		password = append(password, r)
+	}
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", 0, err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return string(password), float64(p.length) * math.Log2(float64(len(pool))), nil
+}
+
+// loadWordlist reads a wordlist file with one word per line. Lines in the
+// diceware format, where the word follows the dice roll, are accepted too.
+func loadWordlist(path string) ([]string, error) {
+	expandedPath, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(expandedPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseWordlist(string(data)), nil
+}
+
+func parseWordlist(data string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		word := fields[len(fields)-1]
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func (p *passwordPolicy) generatePassphrase() (string, float64, error) {
+	var words []string
+	if p.wordlist != "" {
+		var err error
+		if words, err = loadWordlist(p.wordlist); err != nil {
+			return "", 0, err
+		}
+	} else {
+		words = parseWordlist(effWordlist)
+	}
+	if len(words) < 2 {
+		return "", 0, errors.New("wordlist is too short")
+	}
+
+	phrase := make([]string, p.words)
+	for i := range phrase {
+		j, err := randomInt(len(words))
+		if err != nil {
+			return "", 0, err
+		}
+		phrase[i] = words[j]
+		if p.capitalize {
+			r := []rune(phrase[i])
+			r[0] = unicode.ToUpper(r[0])
+			phrase[i] = string(r)
+		}
+	}
+	bits := float64(p.words) * math.Log2(float64(len(words)))
+
+	inject := func(set string) error {
+		r, err := randomRune([]rune(set))
+		if err != nil {
+			return err
+		}
+		i, err := randomInt(len(phrase))
+		if err != nil {
+			return err
+		}
+		phrase[i] += string(r)
+		bits += math.Log2(float64(len(set))) + math.Log2(float64(len(phrase)))
+		return nil
+	}
+	if p.injectDigit {
+		if err := inject(digitChars); err != nil {
+			return "", 0, err
+		}
+	}
+	if p.injectSymbol {
+		if err := inject(symbolChars); err != nil {
+			return "", 0, err
+		}
+	}
+
+	return strings.Join(phrase, p.separator), bits, nil
+}
@@ -3,6 +3,7 @@ module github.com/rogaps/kp2cli
 go 1.13
 
 require (
+	github.com/aead/argon2 v0.0.0-20180111183520-a87724528b07
 	github.com/atotto/clipboard v0.1.2
 	github.com/google/shlex v0.0.0-20181106134648-c34317bd91bf
 	github.com/mitchellh/go-homedir v1.1.0
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// importItem is an entry read from another password manager, before it is
+// added to the database.
+type importItem struct {
+	group    []string // group path below the import target
+	title    string
+	username string
+	password string
+	url      string
+	notes    string
+	otp      string // otpauth URI or bare base32 secret
+	tags     string
+	fields   []gokeepasslib.ValueData
+}
+
+// setDefaultTitle names an untitled item after the host of its URL, or
+// else its username.
+func (item *importItem) setDefaultTitle() {
+	if item.title != "" {
+		return
+	}
+	if u, err := url.Parse(item.url); err == nil && u.Hostname() != "" {
+		item.title = u.Hostname()
+	} else if item.url != "" {
+		item.title = item.url
+	} else if item.username != "" {
+		item.title = item.username
+	} else {
+		item.title = "Untitled"
+	}
+}
+
+func (item *importItem) key() string {
+	return strings.ToLower(item.title) + "\x00" + item.username + "\x00" + item.url
+}
+
+func (item *importItem) entry(d *gokeepasslib.Database, title string) gokeepasslib.Entry {
+	entry := newEntry(d)
+	entry.Values = append(entry.Values,
+		mkValue("Title", title),
+		mkValue("UserName", item.username),
+		mkProtectedValue("Password", item.password),
+		mkValue("URL", item.url),
+		mkValue("Notes", item.notes),
+	)
+	if item.otp != "" {
+		otp := item.otp
+		if !strings.HasPrefix(otp, "otpauth://") {
+			c := newOTPConfig()
+			if secret, err := decodeBase32(otp); err == nil {
+				c.secret = secret
+				otp = c.uri(item.title, item.username)
+			}
+		}
+		entry.Values = append(entry.Values, mkProtectedValue(otpField, otp))
+	}
+	for _, field := range item.fields {
+		if field.Key == "" || isStandardField(field.Key) || getEntryValue(&entry, field.Key) != nil {
+			continue
+		}
+		entry.Values = append(entry.Values, field)
+	}
+	entry.Tags = item.tags
+	return entry
+}
+
+// importPlan is the outcome of an import, worked out before the database is
+// changed so that it can be shown as a dry run.
+type importPlan struct {
+	target     workingGroup
+	items      []importItem
+	duplicates []importItem
+	groups     []string // paths of the groups to create
+}
+
+func groupPathBelow(wg workingGroup, group []string) string {
+	path := wg.String()
+	for _, name := range group {
+		path = strings.TrimSuffix(path, "/") + "/" + name
+	}
+	return path
+}
+
+// duplicateTag flags imported entries that duplicate another entry.
+const duplicateTag = "Duplicate"
+
+func addTag(tags string, tag string) string {
+	if tags == "" {
+		return tag
+	}
+	return tags + ";" + tag
+}
+
+// planImport sorts items into those to add and those already in the
+// database, or earlier in items, with the same title, username and URL.
+// Duplicates are added too, tagged duplicateTag, when keepDuplicates is set.
+func planImport(d *gokeepasslib.Database, target workingGroup, items []importItem, keepDuplicates bool) (*importPlan, error) {
+	plan := &importPlan{target: target}
+	seen := map[string]bool{}
+	err := walkEntries(newRootGroup(d), func(wg workingGroup, entry *gokeepasslib.Entry) error {
+		item := importItem{
+			title:    entry.GetTitle(),
+			username: getEntryContent(*entry, "UserName"),
+			url:      getEntryContent(*entry, "URL"),
+		}
+		seen[item.key()] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	newGroups := map[string]bool{}
+	for _, item := range items {
+		if seen[item.key()] {
+			plan.duplicates = append(plan.duplicates, item)
+			if !keepDuplicates {
+				continue
+			}
+			item.tags = addTag(item.tags, duplicateTag)
+		}
+		seen[item.key()] = true
+		plan.items = append(plan.items, item)
+
+		wg := target
+		for i, name := range item.group {
+			if wg != nil {
+				if next, err := wg.ChGroup(name); err == nil {
+					wg = next
+					continue
+				}
+				wg = nil
+			}
+			path := groupPathBelow(target, item.group[:i+1])
+			if !newGroups[path] {
+				newGroups[path] = true
+				plan.groups = append(plan.groups, path)
+			}
+		}
+	}
+	return plan, nil
+}
+
+func (plan *importPlan) printSummary(dryRun bool) {
+	verb := "Importing"
+	if dryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d entries into %s\n", verb, len(plan.items), plan.target.String())
+	for _, path := range plan.groups {
+		fmt.Printf("  new group  %s\n", path)
+	}
+	for _, item := range plan.duplicates {
+		path := groupPathBelow(plan.target, item.group)
+		fmt.Printf("  duplicate  %s\n", strings.TrimSuffix(path, "/")+"/"+item.title)
+	}
+	if len(plan.duplicates) > 0 {
+		fmt.Printf("%d duplicates (same title, username and URL)\n", len(plan.duplicates))
+	}
+}
+
+// apply adds the planned entries, creating their groups as needed. An
+// entry whose title is taken in its group gets a numbered title. changed
+// reports whether d was modified, which it can be even on an error.
+func (plan *importPlan) apply(d *gokeepasslib.Database) (changed bool, err error) {
+	for _, item := range plan.items {
+		wg := plan.target
+		for _, name := range item.group {
+			next, err := wg.ChGroup(name)
+			if err != nil {
+				if next, err = addGroup(d, wg, name); err != nil {
+					return changed, err
+				}
+				changed = true
+			}
+			wg = next
+		}
+
+		group := wg.Group()
+		title := item.title
+		for n := 2; hasEntryTitle(group, title); n++ {
+			title = fmt.Sprintf("%s (%d)", item.title, n)
+		}
+		group.Entries = append(group.Entries, item.entry(d, title))
+		changed = true
+	}
+	return changed, nil
+}
+
+func hasEntryTitle(group *gokeepasslib.Group, title string) bool {
+	for _, entry := range group.Entries {
+		if entry.GetTitle() == title {
+			return true
+		}
+	}
+	return false
+}
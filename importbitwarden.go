@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Bitwarden item and custom field types.
+const (
+	bitwardenLogin    = 1
+	bitwardenNote     = 2
+	bitwardenCard     = 3
+	bitwardenIdentity = 4
+
+	bitwardenHiddenField = 1
+)
+
+type bitwardenExport struct {
+	Encrypted   bool              `json:"encrypted"`
+	Folders     []bitwardenFolder `json:"folders"`
+	Collections []bitwardenFolder `json:"collections"`
+	Items       []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	Type          int                    `json:"type"`
+	Name          string                 `json:"name"`
+	Notes         string                 `json:"notes"`
+	Favorite      bool                   `json:"favorite"`
+	FolderID      string                 `json:"folderId"`
+	CollectionIDs []string               `json:"collectionIds"`
+	Fields        []bitwardenField       `json:"fields"`
+	Login         *bitwardenLoginData    `json:"login"`
+	Card          map[string]interface{} `json:"card"`
+	Identity      map[string]interface{} `json:"identity"`
+}
+
+type bitwardenField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  int    `json:"type"`
+}
+
+type bitwardenLoginData struct {
+	URIs     []struct{ URI string } `json:"uris"`
+	Username string                 `json:"username"`
+	Password string                 `json:"password"`
+	TOTP     string                 `json:"totp"`
+}
+
+// bitwardenSecrets are the card and identity properties stored as
+// protected fields.
+var bitwardenSecrets = map[string]bool{"number": true, "code": true, "ssn": true, "passportNumber": true, "licenseNumber": true}
+
+// fieldName turns a Bitwarden property name like cardholderName into
+// "Cardholder Name".
+func fieldName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i == 0 {
+			b.WriteString(strings.ToUpper(string(r)))
+			continue
+		}
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// propertyFields stores the string properties of a card or identity as
+// custom fields, in name order so imports are reproducible.
+func propertyFields(prefix string, properties map[string]interface{}) []gokeepasslib.ValueData {
+	var names []string
+	for name, value := range properties {
+		if s, ok := value.(string); ok && s != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var fields []gokeepasslib.ValueData
+	for _, name := range names {
+		key := prefix + " " + fieldName(name)
+		if bitwardenSecrets[name] {
+			fields = append(fields, mkProtectedValue(key, properties[name].(string)))
+		} else {
+			fields = append(fields, mkValue(key, properties[name].(string)))
+		}
+	}
+	return fields
+}
+
+// readBitwardenJSON reads the items of an unencrypted Bitwarden JSON
+// export. Folders, or the first collection of organization exports,
+// become groups. Login URIs after the first are stored in KP2A_URL_n
+// fields, as KeePass2Android and KeePassXC do.
+func readBitwardenJSON(path string) ([]importItem, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	if export.Encrypted {
+		return nil, errors.New("encrypted bitwarden exports are not supported")
+	}
+	folders := map[string]string{}
+	for _, folder := range append(export.Folders, export.Collections...) {
+		folders[folder.ID] = folder.Name
+	}
+
+	var items []importItem
+	for _, bw := range export.Items {
+		item := importItem{title: bw.Name, notes: bw.Notes}
+		folder := bw.FolderID
+		if folder == "" && len(bw.CollectionIDs) > 0 {
+			folder = bw.CollectionIDs[0]
+		}
+		item.group = splitGroup(folders[folder], "/")
+		if bw.Favorite {
+			item.tags = "Favorite"
+		}
+		if bw.Login != nil {
+			item.username = bw.Login.Username
+			item.password = bw.Login.Password
+			item.otp = bw.Login.TOTP
+			for i, uri := range bw.Login.URIs {
+				if i == 0 {
+					item.url = uri.URI
+				} else {
+					item.fields = append(item.fields, mkValue(fmt.Sprintf("KP2A_URL_%d", i), uri.URI))
+				}
+			}
+		}
+		switch bw.Type {
+		case bitwardenCard:
+			item.fields = append(item.fields, propertyFields("Card", bw.Card)...)
+		case bitwardenIdentity:
+			item.fields = append(item.fields, propertyFields("Identity", bw.Identity)...)
+		}
+		for _, field := range bw.Fields {
+			if field.Type == bitwardenHiddenField {
+				item.fields = append(item.fields, mkProtectedValue(field.Name, field.Value))
+			} else {
+				item.fields = append(item.fields, mkValue(field.Name, field.Value))
+			}
+		}
+		item.setDefaultTitle()
+		items = append(items, item)
+	}
+	return items, nil
+}
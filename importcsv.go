@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Columns of an importItem, named by the csvFormat column maps.
+const (
+	colTitle    = "title"
+	colUserName = "username"
+	colPassword = "password"
+	colURL      = "url"
+	colNotes    = "notes"
+	colGroup    = "group"
+	colOTP      = "totp"
+	colTags     = "tags"
+	colFields   = "fields" // "name: value" lines, as exported by Bitwarden
+)
+
+// csvFormat describes the CSV export of a password manager.
+type csvFormat struct {
+	name      string
+	columns   map[string]string // header name to importItem column
+	detect    []string          // header names that together identify this format
+	groupSep  string            // separator of nested folders in colGroup
+	rootGroup bool              // group paths start with the name of the root group
+	fixup     func(item *importItem)
+}
+
+var csvFormats = []csvFormat{
+	{
+		name: "keepassxc",
+		columns: map[string]string{
+			"group": colGroup, "title": colTitle, "username": colUserName, "password": colPassword,
+			"url": colURL, "notes": colNotes, "totp": colOTP,
+		},
+		detect: []string{
+			"group", "title", "username", "password", "url", "notes", "totp", "icon", "last modified", "created",
+		},
+		groupSep:  "/",
+		rootGroup: true,
+	},
+	{
+		name: "bitwarden",
+		columns: map[string]string{
+			"folder": colGroup, "name": colTitle, "notes": colNotes, "fields": colFields,
+			"login_uri": colURL, "login_username": colUserName, "login_password": colPassword, "login_totp": colOTP,
+		},
+		detect:   []string{"login_password"},
+		groupSep: "/",
+	},
+	{
+		name: "lastpass",
+		columns: map[string]string{
+			"url": colURL, "username": colUserName, "password": colPassword, "totp": colOTP,
+			"extra": colNotes, "name": colTitle, "grouping": colGroup,
+		},
+		detect:   []string{"grouping"},
+		groupSep: "\\",
+		fixup: func(item *importItem) {
+			// LastPass gives secure notes this placeholder URL.
+			if item.url == "http://sn" {
+				item.url = ""
+			}
+		},
+	},
+	{
+		name: "firefox",
+		columns: map[string]string{
+			"url": colURL, "username": colUserName, "password": colPassword,
+		},
+		detect: []string{"httprealm"},
+	},
+	{
+		name: "chrome",
+		columns: map[string]string{
+			"name": colTitle, "url": colURL, "username": colUserName, "password": colPassword, "note": colNotes,
+		},
+		detect: []string{"name", "url", "username", "password"},
+	},
+	{
+		name: "custom",
+		columns: map[string]string{
+			"title":    colTitle,
+			"name":     colTitle,
+			"username": colUserName,
+			"user":     colUserName,
+			"login":    colUserName,
+			"password": colPassword,
+			"url":      colURL,
+			"uri":      colURL,
+			"notes":    colNotes,
+			"note":     colNotes,
+			"group":    colGroup,
+			"folder":   colGroup,
+			"totp":     colOTP,
+			"otp":      colOTP,
+			"tags":     colTags,
+		},
+		groupSep: "/",
+	},
+}
+
+func findCSVFormat(name string) (*csvFormat, error) {
+	for i := range csvFormats {
+		if csvFormats[i].name == name {
+			return &csvFormats[i], nil
+		}
+	}
+	return nil, errors.New("unknown csv format " + name + ", must be chrome, firefox, bitwarden, lastpass, keepassxc or custom")
+}
+
+// detectCSVFormat picks the format whose distinctive columns are all in
+// header, falling back to custom.
+func detectCSVFormat(header []string) *csvFormat {
+	names := map[string]bool{}
+	for _, name := range header {
+		names[name] = true
+	}
+	for i := range csvFormats {
+		detected := len(csvFormats[i].detect) > 0
+		for _, name := range csvFormats[i].detect {
+			detected = detected && names[name]
+		}
+		if detected {
+			return &csvFormats[i]
+		}
+	}
+	format, _ := findCSVFormat("custom")
+	return format
+}
+
+// stripRootGroup removes the root group from the group paths of items,
+// when they all start with the same one.
+func stripRootGroup(items []importItem) {
+	var root string
+	for _, item := range items {
+		switch {
+		case len(item.group) == 0:
+			return
+		case root == "":
+			root = item.group[0]
+		case item.group[0] != root:
+			return
+		}
+	}
+	for i := range items {
+		items[i].group = items[i].group[1:]
+	}
+}
+
+// parseFieldLines reads the "name: value" lines of Bitwarden's fields
+// column.
+func parseFieldLines(s string) []gokeepasslib.ValueData {
+	var fields []gokeepasslib.ValueData
+	for _, line := range strings.Split(s, "\n") {
+		i := strings.Index(line, ": ")
+		if i <= 0 {
+			continue
+		}
+		fields = append(fields, mkValue(line[:i], line[i+2:]))
+	}
+	return fields
+}
+
+func splitGroup(path string, sep string) []string {
+	var group []string
+	for _, name := range strings.Split(path, sep) {
+		if name = strings.TrimSpace(name); name != "" {
+			group = append(group, name)
+		}
+	}
+	return group
+}
+
+// readCSV reads the items of a CSV export. formatName may be empty to
+// detect the format from the header. columns, when given, name the columns
+// of a custom file that has no header row, "-" skipping one. Other columns
+// of a custom file that map to no item field become custom string fields.
+func readCSV(r io.Reader, formatName string, columns []string) ([]importItem, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header := columns
+	if header == nil {
+		var err error
+		if header, err = cr.Read(); err == io.EOF {
+			return nil, errors.New("empty csv file")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+
+	format := detectCSVFormat(header)
+	if columns != nil {
+		formatName = "custom"
+	}
+	if formatName != "" {
+		var err error
+		if format, err = findCSVFormat(formatName); err != nil {
+			return nil, err
+		}
+	}
+	hasPassword := false
+	for _, name := range header {
+		hasPassword = hasPassword || format.columns[name] == colPassword
+	}
+	if !hasPassword {
+		return nil, errors.New("no password column for the " + format.name + " format")
+	}
+
+	var items []importItem
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var item importItem
+		for i, value := range record {
+			if i >= len(header) {
+				break
+			}
+			switch format.columns[header[i]] {
+			case colTitle:
+				item.title = value
+			case colUserName:
+				item.username = value
+			case colPassword:
+				item.password = value
+			case colURL:
+				item.url = value
+			case colNotes:
+				item.notes = value
+			case colGroup:
+				item.group = splitGroup(value, format.groupSep)
+			case colOTP:
+				item.otp = value
+			case colTags:
+				item.tags = value
+			case colFields:
+				item.fields = append(item.fields, parseFieldLines(value)...)
+			default:
+				if format.name == "custom" && value != "" && header[i] != "-" {
+					item.fields = append(item.fields, mkValue(header[i], value))
+				}
+			}
+		}
+		if format.fixup != nil {
+			format.fixup(&item)
+		}
+		item.setDefaultTitle()
+		items = append(items, item)
+	}
+	if format.rootGroup {
+		stripRootGroup(items)
+	}
+	return items, nil
+}
+
+func readCSVFile(path string, formatName string, columns []string) ([]importItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCSV(f, formatName, columns)
+}
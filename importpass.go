@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const passExtension = ".gpg"
+
+// decryptPassFile returns the content of a password-store file, decrypted
+// with gpg when it has the .gpg extension.
+func decryptPassFile(path string) ([]byte, error) {
+	if filepath.Ext(path) != passExtension {
+		return ioutil.ReadFile(path)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--quiet", "--batch", "--decrypt", path)
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return data, nil
+}
+
+// parsePassFile reads a password-store file: the password on the first
+// line, followed by "key: value" lines and free text, which goes to the
+// notes.
+func parsePassFile(item *importItem, data string) {
+	lines := strings.Split(strings.TrimRight(data, "\n"), "\n")
+	item.password = strings.TrimSuffix(lines[0], "\r")
+	var notes []string
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, "otpauth://") {
+			item.otp = line
+			continue
+		}
+		i := strings.Index(line, ": ")
+		if i <= 0 {
+			notes = append(notes, line)
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2:])
+		switch strings.ToLower(key) {
+		case "login", "user", "username":
+			item.username = value
+		case "url", "website":
+			item.url = value
+		case "otp", "totp":
+			item.otp = value
+		default:
+			item.fields = append(item.fields, mkValue(key, value))
+		}
+	}
+	item.notes = strings.TrimSpace(strings.Join(notes, "\n"))
+}
+
+// readPassStore reads the entries of a password-store directory. Files
+// ending in .gpg are decrypted with gpg; other files are read as they
+// are, so an already decrypted copy of the store can be imported.
+// Directories become groups.
+func readPassStore(dir string) ([]importItem, error) {
+	var items []importItem
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := decryptPassFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		groupPath, name := filepath.Split(rel)
+		item := importItem{
+			group: splitGroup(filepath.ToSlash(groupPath), "/"),
+			title: strings.TrimSuffix(name, passExtension),
+		}
+		parsePassFile(&item, string(data))
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
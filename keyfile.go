@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// keyFileXML is the KeePass 2.x XML key file, version 1.0 with base64 key
+// data or version 2.0 with hex key data and a hash to check it.
+type keyFileXML struct {
+	XMLName xml.Name `xml:"KeyFile"`
+	Meta    struct {
+		Version string `xml:"Version"`
+	} `xml:"Meta"`
+	Key struct {
+		Data struct {
+			Hash  string `xml:"Hash,attr,omitempty"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"Key"`
+}
+
+func isHex(data []byte) bool {
+	_, err := hex.DecodeString(string(data))
+	return err == nil
+}
+
+// parseXMLKeyFile reads the key of an XML key file. ok is false when data
+// is not one.
+func parseXMLKeyFile(data []byte) (key []byte, ok bool, err error) {
+	var keyFile keyFileXML
+	if xml.Unmarshal(data, &keyFile) != nil || keyFile.Key.Data.Value == "" {
+		return nil, false, nil
+	}
+	value := strings.Join(strings.Fields(keyFile.Key.Data.Value), "")
+	switch {
+	case strings.HasPrefix(keyFile.Meta.Version, "1."):
+		key, err = base64.StdEncoding.DecodeString(value)
+		return key, true, err
+	case strings.HasPrefix(keyFile.Meta.Version, "2."):
+		if key, err = hex.DecodeString(value); err != nil {
+			return nil, true, err
+		}
+		hash := sha256.Sum256(key)
+		if keyFile.Key.Data.Hash != "" && !strings.EqualFold(keyFile.Key.Data.Hash, hex.EncodeToString(hash[:4])) {
+			return nil, true, errors.New("key file hash mismatch, the key file is damaged")
+		}
+		return key, true, nil
+	}
+	return nil, true, errors.New("unsupported key file version " + keyFile.Meta.Version)
+}
+
+// parseKeyData returns the key of a key file the way KeePass reads it: an
+// XML key file, 32 raw bytes, 64 hex characters, or else the SHA-256 hash
+// of the whole file.
+func parseKeyData(data []byte) ([]byte, error) {
+	if key, ok, err := parseXMLKeyFile(data); ok {
+		return key, err
+	}
+	switch trimmed := bytes.TrimSpace(data); {
+	case len(data) == 32:
+		return data, nil
+	case len(trimmed) == 64 && isHex(trimmed):
+		return hex.DecodeString(string(trimmed))
+	}
+	hash := sha256.Sum256(data)
+	return hash[:], nil
+}
+
+func parseKeyFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyData(data)
+}
+
+// newCredentials builds the composite key of a password, a key file or
+// both. A nil password leaves it out, an empty keyPath the key file.
+func newCredentials(password *string, keyPath string) (*gokeepasslib.DBCredentials, error) {
+	credentials := &gokeepasslib.DBCredentials{}
+	if password != nil {
+		hash := sha256.Sum256([]byte(*password))
+		credentials.Passphrase = hash[:]
+	}
+	if keyPath != "" {
+		key, err := parseKeyFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		credentials.Key = key
+	}
+	if credentials.Passphrase == nil && credentials.Key == nil {
+		return nil, errors.New("either a password or a key file is needed")
+	}
+	return credentials, nil
+}
+
+// generateKey returns 32 random bytes. Extra entropy from the user is
+// hashed together with them, so it can only add to their randomness.
+func generateKey(entropy []byte) ([]byte, error) {
+	key, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	if len(entropy) == 0 {
+		return key, nil
+	}
+	hash := sha256.Sum256(append(key, entropy...))
+	return hash[:], nil
+}
+
+// xmlKeyFile returns key as a version 2.0 XML key file, laid out like the
+// ones KeePass writes.
+func xmlKeyFile(key []byte) []byte {
+	hash := sha256.Sum256(key)
+	data := strings.ToUpper(hex.EncodeToString(key))
+	var groups []string
+	for i := 0; i < len(data); i += 8 {
+		groups = append(groups, data[i:i+8])
+	}
+	var lines []string
+	for i := 0; i < len(groups); i += 4 {
+		lines = append(lines, "\t\t\t"+strings.Join(groups[i:i+4], " "))
+	}
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<KeyFile>
+	<Meta>
+		<Version>2.0</Version>
+	</Meta>
+	<Key>
+		<Data Hash="%s">
+%s
+		</Data>
+	</Key>
+</KeyFile>
+`, strings.ToUpper(hex.EncodeToString(hash[:4])), strings.Join(lines, "\n")))
+}
+
+// writeKeyFile writes data to a new file at path, readable only by its
+// owner. It never overwrites an existing file, which may be the key of a
+// database.
+func writeKeyFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
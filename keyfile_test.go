@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kp2cli-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		file func(key []byte) []byte
+	}{
+		{"xml", xmlKeyFile},
+		{"binary", func(key []byte) []byte { return key }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := generateKey([]byte("typed in"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyPath := filepath.Join(dir, tt.name+".key")
+			if err := writeKeyFile(keyPath, tt.file(key)); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := parseKeyFile(keyPath); err != nil || !bytes.Equal(got, key) {
+				t.Fatalf("parseKeyFile = %x, %v, want %x", got, err, key)
+			}
+
+			password := "pw"
+			credentials, err := newCredentials(&password, keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := createDatabase(databaseOptions{version: "4", cipher: "aes", kdf: "aes", rounds: 1}, "test.kdbx")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Credentials = credentials
+			entry := newEntry(d)
+			entry.Values = []gokeepasslib.ValueData{mkValue("Title", "GitHub"), mkProtectedValue("Password", "ghpw")}
+			d.Content.Root.Groups[0].Entries = []gokeepasslib.Entry{entry}
+			dbPath := filepath.Join(dir, tt.name+".kdbx")
+			if err := saveDatabase(d, dbPath); err != nil {
+				t.Fatal(err)
+			}
+
+			if credentials, err = newCredentials(&password, keyPath); err != nil {
+				t.Fatal(err)
+			}
+			opened, err := decodeDatabase(dbPath, credentials)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := opened.Content.Root.Groups[0].Entries[0].GetPassword(); got != "ghpw" {
+				t.Errorf("password = %q, want ghpw", got)
+			}
+
+			other, err := generateKey(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			credentials.Key = other
+			if _, err := decodeDatabase(dbPath, credentials); err == nil {
+				t.Error("opened the database with another key")
+			}
+		})
+	}
+}
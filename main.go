@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/rogaps/kp2cli/terminal"
@@ -10,8 +13,10 @@ import (
 )
 
 var (
-	db  *gokeepasslib.Database
-	cwd workingGroup
+	db     *gokeepasslib.Database
+	dbPath string
+	dirty  bool
+	cwd    workingGroup
 )
 
 func setCwd(t *terminal.Term, c workingGroup) {
@@ -19,15 +24,107 @@ func setCwd(t *terminal.Term, c workingGroup) {
 	t.SetPrompt(fmt.Sprintf("%s:%s> ", "kp2cli", cwd.String()))
 }
 
-func setDb(t *terminal.Term, d *gokeepasslib.Database) {
+// refreshCwd resolves cwd again from the root group. Pointers held by a
+// workingGroup go stale once the group slices they point into are modified.
+func refreshCwd(t *terminal.Term) {
+	wg, _ := travel(newRootGroup(db), cwd.String())
+	setCwd(t, wg)
+}
+
+func setDb(t *terminal.Term, d *gokeepasslib.Database, path string) {
 	db = d
+	dbPath = path
+	dirty = false
 	setCwd(t, newRootGroup(db))
 }
 
+// readLine reads one line from r without reading ahead, leaving the rest
+// of r to whoever reads it next.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+func flagPassword(fromStdin bool, fromEnv string, fromFd int) (string, bool, error) {
+	switch {
+	case fromStdin:
+		password, err := readLine(os.Stdin)
+		return password, true, err
+	case fromEnv != "":
+		password, ok := os.LookupEnv(fromEnv)
+		if !ok {
+			return "", false, fmt.Errorf("environment variable %s is not set", fromEnv)
+		}
+		return password, true, nil
+	case fromFd >= 0:
+		f := os.NewFile(uintptr(fromFd), "password-fd")
+		defer f.Close()
+		password, err := readLine(f)
+		return password, true, err
+	}
+	return "", false, nil
+}
+
+func flagCredentials(t *terminal.Term, keyPath string, password string, hasPassword bool, noPassword bool) (*gokeepasslib.DBCredentials, error) {
+	if !hasPassword {
+		return promptCredentials(t, "Enter password: ", keyPath, noPassword)
+	}
+	expandedKeyPath, err := homedir.Expand(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return newCredentials(&password, expandedKeyPath)
+}
+
+func fatal(t *terminal.Term, err error) {
+	t.Close()
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
+
 func main() {
 	var err error
 	var historyFilePath string
-	var exitCode int
+	var dbFile, keyFile, command, script, passwordEnv string
+	var passwordStdin, noPassword, jsonOut bool
+	var passwordFd int
+
+	flag.StringVar(&dbFile, "d", "", "database `file` to open")
+	flag.StringVar(&keyFile, "k", "", "key `file` of the database")
+	flag.StringVar(&command, "c", "", "run `command` and exit")
+	flag.StringVar(&script, "f", "", "run the commands in `file` and exit")
+	flag.BoolVar(&passwordStdin, "password-stdin", false, "read the database password from the first line of stdin")
+	flag.StringVar(&passwordEnv, "password-env", "", "read the database password from the environment `variable`")
+	flag.IntVar(&passwordFd, "password-fd", -1, "read the database password from the file descriptor `fd`")
+	flag.BoolVar(&noPassword, "no-password", false, "open the database with the key file only")
+	flag.BoolVar(&jsonOut, "json", false, "print ls, find, show, tree and info output as JSON")
+	flag.Parse()
+	if jsonOut {
+		outputFormat = jsonOutput
+	}
+	if command != "" && script != "" {
+		fmt.Fprintln(os.Stderr, "Error: -c and -f cannot be used together")
+		os.Exit(2)
+	}
+	if dbFile == "" && (keyFile != "" || noPassword) {
+		fmt.Fprintln(os.Stderr, "Error: -k and -no-password need a database given with -d")
+		os.Exit(2)
+	}
 
 	t := terminal.NewTerm(&terminal.TermConfig{})
 	t.SetCommands(cmds...)
@@ -36,16 +133,51 @@ func main() {
 		historyFilePath = ".kp2cli_history"
 	}
 	t.SetHistoryFile(historyFilePath)
-	setDb(t, newDatabase())
+	t.SetExitHandler(copied.flush)
+	t.SetQuitHandler(func() bool {
+		ok, err := confirmDiscard(t)
+		return ok || err == io.EOF
+	})
+	setDb(t, newDatabase(), "")
 	setCwd(t, newRootGroup(db))
 
+	if dbFile != "" {
+		password, hasPassword, err := flagPassword(passwordStdin, passwordEnv, passwordFd)
+		if err != nil {
+			fatal(t, err)
+		}
+		credentials, err := flagCredentials(t, keyFile, password, hasPassword, noPassword)
+		if err != nil {
+			fatal(t, err)
+		}
+		if err := openDatabase(t, dbFile, credentials); err != nil {
+			fatal(t, err)
+		}
+	}
+
+	if command != "" || script != "" {
+		if command != "" {
+			// A command that stops the terminal, like exit, succeeded.
+			if err = t.CallCmd(command); !t.Running() {
+				err = nil
+			}
+		} else {
+			err = sourceFile(t, script)
+		}
+		copied.wait()
+		if err != nil {
+			fatal(t, err)
+		}
+		if dirty {
+			fmt.Fprintln(os.Stderr, "Warning: unsaved changes were discarded")
+		}
+		t.Close()
+		return
+	}
+
 	fmt.Println("kp2cli, Keepass 2 Interactive Shell")
 	fmt.Println("Type \"help\" for help.")
 	fmt.Println()
 
 	t.Run()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(exitCode)
-	}
 }
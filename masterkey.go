@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// sameKey reports whether key matches the current one, without leaking
+// where they differ.
+func sameKey(current []byte, key []byte) bool {
+	return current != nil && subtle.ConstantTimeCompare(current, key) == 1
+}
+
+// rekeyHeader returns a copy of the file headers of d, and of its inner
+// header for KDBX 4, with new random seeds, IV, KDF salt and protected
+// stream key, so that nothing derived from the old master key is reused.
+func rekeyHeader(d *gokeepasslib.Database) (*gokeepasslib.FileHeaders, *gokeepasslib.InnerHeader, error) {
+	fh := *d.Header.FileHeaders
+	var err error
+	if fh.MasterSeed, err = randomBytes(32); err != nil {
+		return nil, nil, err
+	}
+	if fh.EncryptionIV, err = randomBytes(len(fh.EncryptionIV)); err != nil {
+		return nil, nil, err
+	}
+
+	if !d.Header.IsKdbx4() {
+		if fh.TransformSeed, err = randomBytes(32); err != nil {
+			return nil, nil, err
+		}
+		if fh.ProtectedStreamKey, err = randomBytes(32); err != nil {
+			return nil, nil, err
+		}
+		if fh.StreamStartBytes, err = randomBytes(32); err != nil {
+			return nil, nil, err
+		}
+		return &fh, d.Content.InnerHeader, nil
+	}
+
+	kdf := *fh.KdfParameters
+	salt, err := randomBytes(len(kdf.Salt))
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(kdf.Salt[:], salt)
+	encodeKdfParameters(&kdf)
+	fh.KdfParameters = &kdf
+
+	ih := *d.Content.InnerHeader
+	if ih.InnerRandomStreamKey, err = randomBytes(len(ih.InnerRandomStreamKey)); err != nil {
+		return nil, nil, err
+	}
+	return &fh, &ih, nil
+}
+
+// masterKeyWarning returns a warning when the master key of d is older than
+// its database settings allow or recommend, or an empty string.
+func masterKeyWarning(d *gokeepasslib.Database, now time.Time) string {
+	meta := d.Content.Meta
+	if meta.MasterKeyChanged == nil {
+		return ""
+	}
+	days := int64(now.Sub(meta.MasterKeyChanged.Time).Hours() / 24)
+	switch {
+	case meta.MasterKeyChangeForce >= 0 && days >= meta.MasterKeyChangeForce:
+		return fmt.Sprintf("the master key was changed %d days ago and must be changed now, use passwd", days)
+	case meta.MasterKeyChangeRec >= 0 && days >= meta.MasterKeyChangeRec:
+		return fmt.Sprintf("the master key was changed %d days ago, changing it with passwd is recommended", days)
+	}
+	return ""
+}
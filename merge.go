@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// objectRef locates a group or an entry of a database.
+type objectRef struct {
+	parent *gokeepasslib.Group // nil for the root group
+	group  *gokeepasslib.Group
+	entry  *gokeepasslib.Entry
+	path   string
+}
+
+// indexObjects maps the UUIDs of root and everything below it to their
+// location. The pointers are only valid until the tree is changed.
+func indexObjects(root *gokeepasslib.Group) map[gokeepasslib.UUID]objectRef {
+	index := map[gokeepasslib.UUID]objectRef{root.UUID: {group: root, path: "/"}}
+	var walk func(group *gokeepasslib.Group, path string)
+	walk = func(group *gokeepasslib.Group, path string) {
+		for i := range group.Groups {
+			sub := &group.Groups[i]
+			subPath := strings.TrimSuffix(path, "/") + "/" + sub.Name
+			index[sub.UUID] = objectRef{parent: group, group: sub, path: subPath}
+			walk(sub, subPath)
+		}
+		for i := range group.Entries {
+			entry := &group.Entries[i]
+			index[entry.UUID] = objectRef{parent: group, entry: entry, path: strings.TrimSuffix(path, "/") + "/" + entry.GetTitle()}
+		}
+	}
+	walk(root, "/")
+	return index
+}
+
+func timeOf(t *w.TimeWrapper) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.Time
+}
+
+// formatTime copies t with the time format of d.
+func formatTime(d *gokeepasslib.Database, t *w.TimeWrapper) *w.TimeWrapper {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	copied.Formatted = !d.Header.IsKdbx4()
+	return &copied
+}
+
+func formatTimes(d *gokeepasslib.Database, times *gokeepasslib.TimeData) {
+	times.CreationTime = formatTime(d, times.CreationTime)
+	times.LastModificationTime = formatTime(d, times.LastModificationTime)
+	times.LastAccessTime = formatTime(d, times.LastAccessTime)
+	times.ExpiryTime = formatTime(d, times.ExpiryTime)
+	times.LocationChanged = formatTime(d, times.LocationChanged)
+}
+
+func databaseBinaries(d *gokeepasslib.Database) *gokeepasslib.Binaries {
+	if d.Header.IsKdbx4() {
+		if d.Content.InnerHeader == nil {
+			d.Content.InnerHeader = &gokeepasslib.InnerHeader{}
+		}
+		return &d.Content.InnerHeader.Binaries
+	}
+	return &d.Content.Meta.Binaries
+}
+
+// binaryContent returns the plain content of an attachment of d. KDBX 4
+// stores it as is, KDBX 3.1 base64 encoded and possibly compressed.
+func binaryContent(d *gokeepasslib.Database, binary *gokeepasslib.Binary) ([]byte, error) {
+	if d.Header.IsKdbx4() {
+		return binary.Content, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(string(binary.Content))
+	if err != nil || !binary.Compressed.Bool {
+		return data, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// addBinary stores an attachment in d and returns its ID.
+func addBinary(d *gokeepasslib.Database, content []byte) (int, error) {
+	binaries := databaseBinaries(d)
+	binary := gokeepasslib.Binary{ID: len(*binaries)}
+	if len(*binaries) > 0 {
+		binary.ID = (*binaries)[len(*binaries)-1].ID + 1
+	}
+	if d.Header.IsKdbx4() {
+		binary.Content = content
+	} else {
+		binary.Compressed = w.NewBoolWrapper(true)
+		if err := binary.SetContent(content); err != nil {
+			return 0, err
+		}
+	}
+	*binaries = append(*binaries, binary)
+	return binary.ID, nil
+}
+
+// copyEntry returns entry of src, without its history, ready to be stored
+// in dst: attachments are copied over and times use the format of dst.
+func copyEntry(dst *gokeepasslib.Database, src *gokeepasslib.Database, entry gokeepasslib.Entry) (gokeepasslib.Entry, error) {
+	entry.Values = append([]gokeepasslib.ValueData(nil), entry.Values...)
+	formatTimes(dst, &entry.Times)
+	binaries := make([]gokeepasslib.BinaryReference, len(entry.Binaries))
+	for i, ref := range entry.Binaries {
+		binaries[i] = ref
+		binary := ref.Find(src)
+		if binary == nil {
+			continue
+		}
+		content, err := binaryContent(src, binary)
+		if err != nil {
+			return entry, err
+		}
+		if binaries[i].Value.ID, err = addBinary(dst, content); err != nil {
+			return entry, err
+		}
+	}
+	entry.Binaries = binaries
+	entry.Histories = nil
+	return entry, nil
+}
+
+func historyEntries(entry *gokeepasslib.Entry) []gokeepasslib.Entry {
+	var entries []gokeepasslib.Entry
+	for _, history := range entry.Histories {
+		entries = append(entries, history.Entries...)
+	}
+	return entries
+}
+
+// mergeSummary lists the paths of the objects changed by a merge.
+type mergeSummary struct {
+	added   []string
+	updated []string
+	deleted []string
+	moved   []string
+	changed bool
+}
+
+// mergeGroup is a group of the local database while merging. The merge
+// works on this tree of pointers, which stay valid while objects are added
+// and moved, so that the index is only built once, and writes it back when
+// done.
+type mergeGroup struct {
+	group   gokeepasslib.Group // without its groups and entries
+	parent  *mergeGroup        // nil for the root group
+	groups  []*mergeGroup
+	entries []*mergeEntry
+}
+
+type mergeEntry struct {
+	entry  gokeepasslib.Entry
+	parent *mergeGroup
+}
+
+func (g *mergeGroup) path() string {
+	if g.parent == nil {
+		return "/"
+	}
+	return strings.TrimSuffix(g.parent.path(), "/") + "/" + g.group.Name
+}
+
+func (e *mergeEntry) path() string {
+	return strings.TrimSuffix(e.parent.path(), "/") + "/" + e.entry.GetTitle()
+}
+
+// contains tells whether other is g or below it.
+func (g *mergeGroup) contains(other *mergeGroup) bool {
+	for ; other != nil; other = other.parent {
+		if other == g {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *mergeGroup) addGroup(sub *mergeGroup) {
+	sub.parent = g
+	g.groups = append(g.groups, sub)
+}
+
+func (g *mergeGroup) addEntry(entry *mergeEntry) {
+	entry.parent = g
+	g.entries = append(g.entries, entry)
+}
+
+func (g *mergeGroup) removeGroup(sub *mergeGroup) {
+	for i := range g.groups {
+		if g.groups[i] == sub {
+			g.groups = append(g.groups[:i], g.groups[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *mergeGroup) removeEntry(entry *mergeEntry) {
+	for i := range g.entries {
+		if g.entries[i] == entry {
+			g.entries = append(g.entries[:i], g.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// build returns the group with everything below it.
+func (g *mergeGroup) build() gokeepasslib.Group {
+	group := g.group
+	group.Groups = make([]gokeepasslib.Group, len(g.groups))
+	for i, sub := range g.groups {
+		group.Groups[i] = sub.build()
+	}
+	group.Entries = make([]gokeepasslib.Entry, len(g.entries))
+	for i, entry := range g.entries {
+		group.Entries[i] = entry.entry
+	}
+	return group
+}
+
+type merger struct {
+	local   *gokeepasslib.Database
+	remote  *gokeepasslib.Database
+	deleted map[gokeepasslib.UUID]gokeepasslib.DeletedObjectData
+	summary mergeSummary
+
+	root    *mergeGroup
+	groups  map[gokeepasslib.UUID]*mergeGroup
+	entries map[gokeepasslib.UUID]*mergeEntry
+}
+
+// index adds group and everything below it to the tree of the local
+// database.
+func (m *merger) index(group *gokeepasslib.Group, parent *mergeGroup) *mergeGroup {
+	g := &mergeGroup{group: *group, parent: parent}
+	g.group.Groups, g.group.Entries = nil, nil
+	m.groups[group.UUID] = g
+	for i := range group.Groups {
+		g.groups = append(g.groups, m.index(&group.Groups[i], g))
+	}
+	for _, entry := range group.Entries {
+		e := &mergeEntry{entry: entry, parent: g}
+		m.entries[entry.UUID] = e
+		g.entries = append(g.entries, e)
+	}
+	return g
+}
+
+// mergeHistory adds the remote versions in others to the history of entry,
+// unless a version with the same modification time is already there. It
+// returns whether the history changed.
+func (m *merger) mergeHistory(entry *gokeepasslib.Entry, others []gokeepasslib.Entry) (bool, error) {
+	entries := historyEntries(entry)
+	known := map[time.Time]bool{timeOf(entry.Times.LastModificationTime): true}
+	for _, old := range entries {
+		known[timeOf(old.Times.LastModificationTime)] = true
+	}
+	changed := false
+	for _, other := range others {
+		modified := timeOf(other.Times.LastModificationTime)
+		if known[modified] {
+			continue
+		}
+		known[modified] = true
+		copied, err := copyEntry(m.local, m.remote, other)
+		if err != nil {
+			return false, err
+		}
+		entries = append(entries, copied)
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return timeOf(entries[i].Times.LastModificationTime).Before(timeOf(entries[j].Times.LastModificationTime))
+	})
+	if max := m.local.Content.Meta.HistoryMaxItems; max >= 0 && int64(len(entries)) > max {
+		entries = entries[int64(len(entries))-max:]
+	}
+	entry.Histories = []gokeepasslib.History{{Entries: entries}}
+	return true, nil
+}
+
+func (m *merger) localRoot() *gokeepasslib.Group {
+	return &m.local.Content.Root.Groups[0]
+}
+
+// localParent returns the local group matching the remote parent, the
+// roots of both databases matching each other.
+func (m *merger) localParent(remoteParent *gokeepasslib.Group, remoteRoot *gokeepasslib.Group) *mergeGroup {
+	if remoteParent != remoteRoot {
+		if g, ok := m.groups[remoteParent.UUID]; ok {
+			return g
+		}
+	}
+	return m.root
+}
+
+// wasDeleted tells whether the object was deleted after its last change.
+func (m *merger) wasDeleted(uuid gokeepasslib.UUID, times gokeepasslib.TimeData) bool {
+	deleted, ok := m.deleted[uuid]
+	return ok && !timeOf(times.LastModificationTime).After(timeOf(deleted.DeletionTime))
+}
+
+func (m *merger) mergeGroups(remoteParent *gokeepasslib.Group, remoteRoot *gokeepasslib.Group) {
+	for i := range remoteParent.Groups {
+		remote := &remoteParent.Groups[i]
+		parent := m.localParent(remoteParent, remoteRoot)
+		_, isEntry := m.entries[remote.UUID]
+		local, ok := m.groups[remote.UUID]
+		switch {
+		case ok:
+			m.updateGroup(local, remote, parent)
+		case isEntry:
+			continue
+		case m.wasDeleted(remote.UUID, remote.Times):
+			continue
+		default:
+			g := &mergeGroup{group: *remote}
+			g.group.Groups, g.group.Entries = nil, nil
+			formatTimes(m.local, &g.group.Times)
+			parent.addGroup(g)
+			m.groups[remote.UUID] = g
+			m.summary.added = append(m.summary.added, g.path()+"/")
+		}
+		m.mergeGroups(remote, remoteRoot)
+	}
+}
+
+func (m *merger) updateGroup(local *mergeGroup, remote *gokeepasslib.Group, parent *mergeGroup) {
+	path := local.path()
+	if timeOf(remote.Times.LastModificationTime).After(timeOf(local.group.Times.LastModificationTime)) {
+		group := *remote
+		group.Groups, group.Entries = nil, nil
+		group.Times.LocationChanged = local.group.Times.LocationChanged
+		formatTimes(m.local, &group.Times)
+		local.group = group
+		m.summary.updated = append(m.summary.updated, path+"/")
+	}
+	if local.parent == nil || local.parent == parent ||
+		!timeOf(remote.Times.LocationChanged).After(timeOf(local.group.Times.LocationChanged)) ||
+		local.contains(parent) {
+		return
+	}
+
+	local.group.Times.LocationChanged = formatTime(m.local, remote.Times.LocationChanged)
+	local.parent.removeGroup(local)
+	parent.addGroup(local)
+	m.summary.moved = append(m.summary.moved, path+"/")
+}
+
+func (m *merger) mergeEntries(remoteParent *gokeepasslib.Group, remoteRoot *gokeepasslib.Group) error {
+	for i := range remoteParent.Entries {
+		remote := &remoteParent.Entries[i]
+		parent := m.localParent(remoteParent, remoteRoot)
+		_, isGroup := m.groups[remote.UUID]
+		local, ok := m.entries[remote.UUID]
+		switch {
+		case ok:
+			if err := m.updateEntry(local, remote, parent); err != nil {
+				return err
+			}
+		case isGroup:
+			continue
+		case m.wasDeleted(remote.UUID, remote.Times):
+			continue
+		default:
+			entry, err := copyEntry(m.local, m.remote, *remote)
+			if err != nil {
+				return err
+			}
+			if _, err := m.mergeHistory(&entry, historyEntries(remote)); err != nil {
+				return err
+			}
+			e := &mergeEntry{entry: entry}
+			parent.addEntry(e)
+			m.entries[remote.UUID] = e
+			m.summary.added = append(m.summary.added, e.path())
+		}
+	}
+	for i := range remoteParent.Groups {
+		if err := m.mergeEntries(&remoteParent.Groups[i], remoteRoot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *merger) updateEntry(local *mergeEntry, remote *gokeepasslib.Entry, parent *mergeGroup) error {
+	path := local.path()
+	localModified := timeOf(local.entry.Times.LastModificationTime)
+	remoteModified := timeOf(remote.Times.LastModificationTime)
+	if remoteModified.After(localModified) {
+		old := local.entry
+		entry, err := copyEntry(m.local, m.remote, *remote)
+		if err != nil {
+			return err
+		}
+		entry.Histories = old.Histories
+		entry.Times.LocationChanged = old.Times.LocationChanged
+		pushHistory(m.local, &entry, old)
+		if _, err := m.mergeHistory(&entry, historyEntries(remote)); err != nil {
+			return err
+		}
+		local.entry = entry
+		m.summary.updated = append(m.summary.updated, path)
+	} else {
+		others := historyEntries(remote)
+		if localModified.After(remoteModified) {
+			others = append(others, *remote)
+		}
+		changed, err := m.mergeHistory(&local.entry, others)
+		if err != nil {
+			return err
+		}
+		m.summary.changed = m.summary.changed || changed
+	}
+
+	if local.parent == parent ||
+		!timeOf(remote.Times.LocationChanged).After(timeOf(local.entry.Times.LocationChanged)) {
+		return nil
+	}
+	local.entry.Times.LocationChanged = formatTime(m.local, remote.Times.LocationChanged)
+	local.parent.removeEntry(local)
+	parent.addEntry(local)
+	m.summary.moved = append(m.summary.moved, path)
+	return nil
+}
+
+// groupDeleted tells whether group and everything in it was deleted after
+// its last change. A group keeping live objects stays.
+func (m *merger) groupDeleted(g *mergeGroup) bool {
+	if !m.wasDeleted(g.group.UUID, g.group.Times) {
+		return false
+	}
+	for _, e := range g.entries {
+		if !m.wasDeleted(e.entry.UUID, e.entry.Times) {
+			return false
+		}
+	}
+	for _, sub := range g.groups {
+		if !m.groupDeleted(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+// forget removes g and everything below it from the index.
+func (m *merger) forget(g *mergeGroup) {
+	delete(m.groups, g.group.UUID)
+	for _, e := range g.entries {
+		delete(m.entries, e.entry.UUID)
+	}
+	for _, sub := range g.groups {
+		m.forget(sub)
+	}
+}
+
+// applyDeletions removes the objects deleted, in either database, after
+// their last change, and keeps the deletion records of both databases.
+func (m *merger) applyDeletions() {
+	var uuids []gokeepasslib.UUID
+	for uuid := range m.deleted {
+		uuids = append(uuids, uuid)
+	}
+	sort.Slice(uuids, func(i, j int) bool { return bytes.Compare(uuids[i][:], uuids[j][:]) < 0 })
+
+	var records []gokeepasslib.DeletedObjectData
+	for _, uuid := range uuids {
+		records = append(records, m.deleted[uuid])
+		if e, ok := m.entries[uuid]; ok && m.wasDeleted(uuid, e.entry.Times) {
+			m.summary.deleted = append(m.summary.deleted, e.path())
+			e.parent.removeEntry(e)
+			delete(m.entries, uuid)
+		} else if g, ok := m.groups[uuid]; ok && g.parent != nil && m.groupDeleted(g) {
+			m.summary.deleted = append(m.summary.deleted, g.path()+"/")
+			g.parent.removeGroup(g)
+			m.forget(g)
+		}
+	}
+	if len(records) != len(m.local.Content.Root.DeletedObjects) {
+		m.summary.changed = true
+	}
+	m.local.Content.Root.DeletedObjects = records
+}
+
+// mergeDatabase merges remote into local the way KeePass synchronizes
+// databases: objects are matched by UUID, the most recently modified
+// version of an entry wins and the other goes to its history, objects
+// follow the most recent move, and deletions from either side are applied
+// to objects not changed since.
+func mergeDatabase(local *gokeepasslib.Database, remote *gokeepasslib.Database) (*mergeSummary, error) {
+	if len(local.Content.Root.Groups) == 0 || len(remote.Content.Root.Groups) == 0 {
+		return nil, errors.New("database without root group")
+	}
+	m := &merger{
+		local:   local,
+		remote:  remote,
+		deleted: map[gokeepasslib.UUID]gokeepasslib.DeletedObjectData{},
+		groups:  map[gokeepasslib.UUID]*mergeGroup{},
+		entries: map[gokeepasslib.UUID]*mergeEntry{},
+	}
+	m.root = m.index(m.localRoot(), nil)
+	for _, d := range []*gokeepasslib.Database{local, remote} {
+		for _, deleted := range d.Content.Root.DeletedObjects {
+			if known, ok := m.deleted[deleted.UUID]; !ok || timeOf(deleted.DeletionTime).After(timeOf(known.DeletionTime)) {
+				deleted.DeletionTime = formatTime(local, deleted.DeletionTime)
+				m.deleted[deleted.UUID] = deleted
+			}
+		}
+	}
+
+	remoteRoot := &remote.Content.Root.Groups[0]
+	m.mergeGroups(remoteRoot, remoteRoot)
+	if err := m.mergeEntries(remoteRoot, remoteRoot); err != nil {
+		return nil, err
+	}
+	m.applyDeletions()
+	*m.localRoot() = m.root.build()
+
+	// Adopt the recycle bin of remote if local has none yet.
+	meta, remoteMeta := local.Content.Meta, remote.Content.Meta
+	if findGroupByUUID(m.localRoot(), meta.RecycleBinUUID) == nil &&
+		findGroupByUUID(m.localRoot(), remoteMeta.RecycleBinUUID) != nil {
+		meta.RecycleBinEnabled = remoteMeta.RecycleBinEnabled
+		meta.RecycleBinUUID = remoteMeta.RecycleBinUUID
+		meta.RecycleBinChanged = formatTime(local, remoteMeta.RecycleBinChanged)
+	}
+	s := &m.summary
+	s.changed = s.changed || len(s.added) > 0 || len(s.updated) > 0 || len(s.deleted) > 0 || len(s.moved) > 0
+	return s, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+// UUIDs of the objects of the merge test databases.
+const (
+	testRoot byte = iota + 1
+	testGroupA
+	testGroupB
+	testGroupC
+	testEntry1
+	testEntry2
+	testEntry3
+)
+
+var mergeBase = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hours int) *w.TimeWrapper {
+	return &w.TimeWrapper{Time: mergeBase.Add(time.Duration(hours) * time.Hour)}
+}
+
+func testUUID(id byte) gokeepasslib.UUID {
+	var uuid gokeepasslib.UUID
+	uuid[0] = id
+	return uuid
+}
+
+func testTimes(hours int) gokeepasslib.TimeData {
+	return gokeepasslib.TimeData{
+		CreationTime:         at(0),
+		LastModificationTime: at(hours),
+		LastAccessTime:       at(hours),
+		LocationChanged:      at(0),
+	}
+}
+
+func testGroup(name string, id byte, entries ...gokeepasslib.Entry) gokeepasslib.Group {
+	return gokeepasslib.Group{Name: name, UUID: testUUID(id), Times: testTimes(0), Entries: entries}
+}
+
+func testEntry(title string, id byte, password string, hours int) gokeepasslib.Entry {
+	return gokeepasslib.Entry{
+		UUID:   testUUID(id),
+		Times:  testTimes(hours),
+		Values: []gokeepasslib.ValueData{mkValue("Title", title), mkProtectedValue("Password", password)},
+	}
+}
+
+// mergeTestDatabase returns a database holding /A/e1 and /B/e2.
+func mergeTestDatabase(t *testing.T) *gokeepasslib.Database {
+	d, err := createDatabase(databaseOptions{version: "4", cipher: "aes", kdf: "aes", rounds: 1}, "test.kdbx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &d.Content.Root.Groups[0]
+	root.UUID = testUUID(testRoot)
+	root.Times = testTimes(0)
+	root.Groups = []gokeepasslib.Group{
+		testGroup("A", testGroupA, testEntry("e1", testEntry1, "pw1", 0)),
+		testGroup("B", testGroupB, testEntry("e2", testEntry2, "pw2", 0)),
+	}
+	return d
+}
+
+func lookup(d *gokeepasslib.Database, id byte) (objectRef, bool) {
+	ref, ok := indexObjects(&d.Content.Root.Groups[0])[testUUID(id)]
+	return ref, ok
+}
+
+func pathOf(d *gokeepasslib.Database, id byte) string {
+	ref, ok := lookup(d, id)
+	if !ok {
+		return ""
+	}
+	return ref.path
+}
+
+func entryOf(d *gokeepasslib.Database, id byte) *gokeepasslib.Entry {
+	ref, _ := lookup(d, id)
+	return ref.entry
+}
+
+// detach removes the object id, if still there, from its group and
+// returns it.
+func detach(d *gokeepasslib.Database, id byte) (*gokeepasslib.Group, *gokeepasslib.Entry) {
+	ref, ok := lookup(d, id)
+	if !ok {
+		return nil, nil
+	}
+	parent := ref.parent
+	for i := range parent.Groups {
+		if parent.Groups[i].UUID == testUUID(id) {
+			group := parent.Groups[i]
+			parent.Groups = append(parent.Groups[:i], parent.Groups[i+1:]...)
+			return &group, nil
+		}
+	}
+	for i := range parent.Entries {
+		if parent.Entries[i].UUID == testUUID(id) {
+			entry := parent.Entries[i]
+			parent.Entries = append(parent.Entries[:i], parent.Entries[i+1:]...)
+			return nil, &entry
+		}
+	}
+	return nil, nil
+}
+
+func move(d *gokeepasslib.Database, id byte, to byte, hours int) {
+	group, entry := detach(d, id)
+	target, _ := lookup(d, to)
+	if group != nil {
+		group.Times.LocationChanged = at(hours)
+		target.group.Groups = append(target.group.Groups, *group)
+	} else {
+		entry.Times.LocationChanged = at(hours)
+		target.group.Entries = append(target.group.Entries, *entry)
+	}
+}
+
+func remove(d *gokeepasslib.Database, hours int, ids ...byte) {
+	for _, id := range ids {
+		detach(d, id)
+		d.Content.Root.DeletedObjects = append(d.Content.Root.DeletedObjects,
+			gokeepasslib.DeletedObjectData{UUID: testUUID(id), DeletionTime: at(hours)})
+	}
+}
+
+func setPassword(d *gokeepasslib.Database, id byte, password string, hours int) {
+	entry := entryOf(d, id)
+	getEntryValue(entry, "Password").Value.Content = password
+	entry.Times.LastModificationTime = at(hours)
+}
+
+func historyPasswords(entry *gokeepasslib.Entry) []string {
+	var passwords []string
+	for _, old := range historyEntries(entry) {
+		passwords = append(passwords, old.GetPassword())
+	}
+	return passwords
+}
+
+func TestMergeDatabase(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(local, remote *gokeepasslib.Database)
+		paths   map[byte]string // "" for objects that must be gone
+		summary mergeSummary
+		check   func(t *testing.T, local *gokeepasslib.Database)
+	}{
+		{
+			name:    "unchanged",
+			change:  func(local, remote *gokeepasslib.Database) {},
+			paths:   map[byte]string{testEntry1: "/A/e1", testEntry2: "/B/e2"},
+			summary: mergeSummary{},
+		},
+		{
+			name: "newer remote entry wins",
+			change: func(local, remote *gokeepasslib.Database) {
+				setPassword(remote, testEntry1, "remote", 1)
+			},
+			paths:   map[byte]string{testEntry1: "/A/e1"},
+			summary: mergeSummary{updated: []string{"/A/e1"}, changed: true},
+			check: func(t *testing.T, local *gokeepasslib.Database) {
+				entry := entryOf(local, testEntry1)
+				if got := entry.GetPassword(); got != "remote" {
+					t.Errorf("password = %q, want remote", got)
+				}
+				if got := historyPasswords(entry); !reflect.DeepEqual(got, []string{"pw1"}) {
+					t.Errorf("history = %q, want [pw1]", got)
+				}
+			},
+		},
+		{
+			name: "newer local entry keeps the remote one in its history",
+			change: func(local, remote *gokeepasslib.Database) {
+				setPassword(local, testEntry1, "local", 2)
+				setPassword(remote, testEntry1, "remote", 1)
+			},
+			paths:   map[byte]string{testEntry1: "/A/e1"},
+			summary: mergeSummary{changed: true},
+			check: func(t *testing.T, local *gokeepasslib.Database) {
+				entry := entryOf(local, testEntry1)
+				if got := entry.GetPassword(); got != "local" {
+					t.Errorf("password = %q, want local", got)
+				}
+				if got := historyPasswords(entry); !reflect.DeepEqual(got, []string{"remote"}) {
+					t.Errorf("history = %q, want [remote]", got)
+				}
+			},
+		},
+		{
+			name: "deleted in remote",
+			change: func(local, remote *gokeepasslib.Database) {
+				remove(remote, 1, testEntry2)
+			},
+			paths:   map[byte]string{testEntry1: "/A/e1", testEntry2: ""},
+			summary: mergeSummary{deleted: []string{"/B/e2"}, changed: true},
+			check: func(t *testing.T, local *gokeepasslib.Database) {
+				if n := len(local.Content.Root.DeletedObjects); n != 1 {
+					t.Errorf("%d deletion records, want 1", n)
+				}
+			},
+		},
+		{
+			name: "deleted in local",
+			change: func(local, remote *gokeepasslib.Database) {
+				remove(local, 1, testEntry2)
+			},
+			paths:   map[byte]string{testEntry2: ""},
+			summary: mergeSummary{},
+		},
+		{
+			name: "deleted group in remote",
+			change: func(local, remote *gokeepasslib.Database) {
+				remove(remote, 1, testGroupB, testEntry2)
+			},
+			paths:   map[byte]string{testGroupB: "", testEntry2: ""},
+			summary: mergeSummary{deleted: []string{"/B/"}, changed: true},
+		},
+		{
+			name: "deletions from both sides",
+			change: func(local, remote *gokeepasslib.Database) {
+				remove(local, 1, testEntry1)
+				remove(remote, 1, testEntry2)
+			},
+			paths:   map[byte]string{testEntry1: "", testEntry2: ""},
+			summary: mergeSummary{deleted: []string{"/B/e2"}, changed: true},
+			check: func(t *testing.T, local *gokeepasslib.Database) {
+				if n := len(local.Content.Root.DeletedObjects); n != 2 {
+					t.Errorf("%d deletion records, want 2", n)
+				}
+			},
+		},
+		{
+			name: "entry changed after its deletion stays",
+			change: func(local, remote *gokeepasslib.Database) {
+				remove(local, 1, testEntry2)
+				setPassword(remote, testEntry2, "remote", 2)
+			},
+			paths:   map[byte]string{testEntry2: "/B/e2"},
+			summary: mergeSummary{added: []string{"/B/e2"}, changed: true},
+		},
+		{
+			name: "entry moved in remote",
+			change: func(local, remote *gokeepasslib.Database) {
+				move(remote, testEntry1, testGroupB, 1)
+			},
+			paths:   map[byte]string{testEntry1: "/B/e1"},
+			summary: mergeSummary{moved: []string{"/A/e1"}, changed: true},
+		},
+		{
+			name: "later local move wins",
+			change: func(local, remote *gokeepasslib.Database) {
+				move(local, testEntry1, testRoot, 2)
+				move(remote, testEntry1, testGroupB, 1)
+			},
+			paths:   map[byte]string{testEntry1: "/e1"},
+			summary: mergeSummary{},
+		},
+		{
+			name: "group moved in remote",
+			change: func(local, remote *gokeepasslib.Database) {
+				move(remote, testGroupB, testGroupA, 1)
+			},
+			paths:   map[byte]string{testGroupB: "/A/B", testEntry2: "/A/B/e2"},
+			summary: mergeSummary{moved: []string{"/B/"}, changed: true},
+		},
+		{
+			name: "added in remote",
+			change: func(local, remote *gokeepasslib.Database) {
+				root := &remote.Content.Root.Groups[0]
+				root.Groups = append(root.Groups, testGroup("C", testGroupC, testEntry("e3", testEntry3, "pw3", 1)))
+			},
+			paths:   map[byte]string{testGroupC: "/C", testEntry3: "/C/e3"},
+			summary: mergeSummary{added: []string{"/C/", "/C/e3"}, changed: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := mergeTestDatabase(t), mergeTestDatabase(t)
+			tt.change(local, remote)
+			summary, err := mergeDatabase(local, remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*summary, tt.summary) {
+				t.Errorf("summary = %+v, want %+v", *summary, tt.summary)
+			}
+			for id, want := range tt.paths {
+				if got := pathOf(local, id); got != want {
+					t.Errorf("path of %d = %q, want %q", id, got, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, local)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+// Types of the values in a KDBX 4 variant dictionary.
+const (
+	variantUInt32 byte = 0x04
+	variantUInt64 byte = 0x05
+	variantBytes  byte = 0x42
+)
+
+const (
+	variantDictionaryVersion uint16 = 0x0100
+	argon2Version            uint32 = 0x13
+)
+
+// Default key derivation settings of new databases.
+const (
+	defaultRounds      = 100000
+	defaultMemory      = 64
+	defaultIterations  = 10
+	defaultParallelism = 2
+)
+
+// maxMemory is the most Argon2 memory, in MiB, whose size in bytes fits the
+// uint32 gokeepasslib v3.0.0 converts it to when it derives the key.
+const maxMemory = math.MaxUint32 / (1024 * 1024)
+
+// kdfArgon2id is the UUID of Argon2id. gokeepasslib only derives keys with
+// Argon2d, so it can be recognized but not used.
+var kdfArgon2id = []byte{0x9E, 0x29, 0x8B, 0x19, 0x56, 0xDB, 0x47, 0x73, 0xB2, 0x3D, 0xFC, 0x3E, 0xC6, 0xF0, 0xA1, 0xE6}
+
+// databaseOptions are the encryption settings of a new database. Memory
+// is in MiB.
+type databaseOptions struct {
+	version     string
+	cipher      string
+	kdf         string
+	rounds      uint64
+	memory      uint64
+	iterations  uint64
+	parallelism uint32
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func variantItem(name string, kind byte, value []byte) *gokeepasslib.VariantDictionaryItem {
+	return &gokeepasslib.VariantDictionaryItem{
+		Type:        kind,
+		NameLength:  int32(len(name)),
+		Name:        []byte(name),
+		ValueLength: int32(len(value)),
+		Value:       value,
+	}
+}
+
+func uint32Item(name string, value uint32) *gokeepasslib.VariantDictionaryItem {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, value)
+	return variantItem(name, variantUInt32, b)
+}
+
+func uint64Item(name string, value uint64) *gokeepasslib.VariantDictionaryItem {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, value)
+	return variantItem(name, variantUInt64, b)
+}
+
+// encodeKdfParameters rebuilds the raw variant dictionary of k from its
+// fields. gokeepasslib derives the key from the fields but only writes the
+// raw dictionary, so both have to agree.
+func encodeKdfParameters(k *gokeepasslib.KdfParameters) {
+	items := []*gokeepasslib.VariantDictionaryItem{
+		variantItem("$UUID", variantBytes, k.UUID),
+		variantItem("S", variantBytes, k.Salt[:]),
+	}
+	if isArgon2(k.UUID) {
+		items = append(items,
+			uint32Item("P", k.Parallelism),
+			uint64Item("M", k.Memory),
+			uint64Item("I", k.Iterations),
+			uint32Item("V", k.Version),
+		)
+	} else {
+		items = append(items, uint64Item("R", k.Rounds))
+	}
+	k.RawData = &gokeepasslib.VariantDictionary{
+		Version: variantDictionaryVersion,
+		Items:   items,
+	}
+}
+
+func isArgon2(uuid []byte) bool {
+	return bytes.Equal(uuid, gokeepasslib.KdfArgon2) || bytes.Equal(uuid, kdfArgon2id)
+}
+
+func cipherID(name string) ([]byte, int, error) {
+	switch strings.ToLower(name) {
+	case "aes", "aes256", "aes-256":
+		return gokeepasslib.CipherAES, 16, nil
+	case "chacha20":
+		return gokeepasslib.CipherChaCha20, 12, nil
+	case "twofish":
+		return nil, 0, errors.New("twofish is not supported by gokeepasslib v3.0.0, use aes or chacha20")
+	}
+	return nil, 0, fmt.Errorf("unknown cipher %s", name)
+}
+
+// newKdfParameters returns the KDBX 4 key derivation settings of opts with
+// a fresh salt.
+func newKdfParameters(opts databaseOptions) (*gokeepasslib.KdfParameters, error) {
+	k := &gokeepasslib.KdfParameters{}
+	switch strings.ToLower(opts.kdf) {
+	case "aes", "aes-kdf":
+		if opts.rounds == 0 {
+			return nil, errors.New("rounds must be positive")
+		}
+		k.UUID = gokeepasslib.KdfAES4
+		k.Rounds = opts.rounds
+	case "argon2d", "argon2":
+		if opts.memory == 0 || opts.iterations == 0 || opts.parallelism == 0 {
+			return nil, errors.New("memory, iterations and parallelism must be positive")
+		}
+		if opts.memory > maxMemory {
+			return nil, fmt.Errorf("memory must be at most %d MiB", maxMemory)
+		}
+		k.UUID = gokeepasslib.KdfArgon2
+		k.Memory = opts.memory * 1024 * 1024
+		k.Iterations = opts.iterations
+		k.Parallelism = opts.parallelism
+		k.Version = argon2Version
+	case "argon2id":
+		return nil, errors.New("argon2id is not supported by gokeepasslib v3.0.0, which only derives argon2d keys, use argon2d")
+	default:
+		return nil, fmt.Errorf("unknown kdf %s", opts.kdf)
+	}
+	salt, err := randomBytes(len(k.Salt))
+	if err != nil {
+		return nil, err
+	}
+	copy(k.Salt[:], salt)
+	encodeKdfParameters(k)
+	return k, nil
+}
+
+// checkKdbx3Kdf checks that opts uses AES-KDF, the only key derivation
+// function of KDBX 3.1.
+func checkKdbx3Kdf(opts databaseOptions) error {
+	if kdf := strings.ToLower(opts.kdf); kdf != "aes" && kdf != "aes-kdf" {
+		return fmt.Errorf("%s needs KDBX 4", opts.kdf)
+	}
+	if opts.rounds == 0 {
+		return errors.New("rounds must be positive")
+	}
+	return nil
+}
+
+// newHeader returns the header of a new database encrypted as opts says.
+func newHeader(opts databaseOptions) (*gokeepasslib.DBHeader, error) {
+	cipher, ivLength, err := cipherID(opts.cipher)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := randomBytes(ivLength)
+	if err != nil {
+		return nil, err
+	}
+
+	// NewHeader points every header at the same default signature, so the
+	// version is changed on a copy.
+	h := gokeepasslib.NewHeader()
+	signature := *h.Signature
+	h.Signature = &signature
+	h.FileHeaders.CipherID = cipher
+	h.FileHeaders.EncryptionIV = iv
+
+	switch opts.version {
+	case "3", "3.1":
+		if ivLength != 16 {
+			return nil, fmt.Errorf("%s needs KDBX 4", opts.cipher)
+		}
+		if err := checkKdbx3Kdf(opts); err != nil {
+			return nil, err
+		}
+		h.FileHeaders.TransformRounds = opts.rounds
+	case "4", "4.0":
+		signature.MajorVersion = 4
+		signature.MinorVersion = 0
+		h.FileHeaders.KdfParameters, err = newKdfParameters(opts)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown version %s, use 3.1 or 4", opts.version)
+	}
+	return h, nil
+}
+
+// createDatabase returns an empty database whose root group is named after
+// the file at path.
+func createDatabase(opts databaseOptions, path string) (*gokeepasslib.Database, error) {
+	header, err := newHeader(opts)
+	if err != nil {
+		return nil, err
+	}
+	d := gokeepasslib.NewDatabase(gokeepasslib.WithDatabaseFormattedTime(!header.IsKdbx4()))
+	d.Header = header
+	if header.IsKdbx4() {
+		key, err := randomBytes(64)
+		if err != nil {
+			return nil, err
+		}
+		d.Content.InnerHeader = &gokeepasslib.InnerHeader{
+			InnerRandomStreamID:  gokeepasslib.ChaChaStreamID,
+			InnerRandomStreamKey: key,
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	d.Content.Meta.DatabaseName = name
+	root := newGroup(d)
+	root.Name = name
+	d.Content.Root.Groups = []gokeepasslib.Group{root}
+	return d, nil
+}
+
+// setKdf changes the key derivation of d to the one of opts, with a new
+// salt. It is used from the next save on.
+func setKdf(d *gokeepasslib.Database, opts databaseOptions) error {
+	if !d.Header.IsKdbx4() {
+		if err := checkKdbx3Kdf(opts); err != nil {
+			return err
+		}
+		seed, err := randomBytes(32)
+		if err != nil {
+			return err
+		}
+		d.Header.FileHeaders.TransformSeed = seed
+		d.Header.FileHeaders.TransformRounds = opts.rounds
+		return nil
+	}
+	k, err := newKdfParameters(opts)
+	if err != nil {
+		return err
+	}
+	d.Header.FileHeaders.KdfParameters = k
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestNewKdfParametersMemory(t *testing.T) {
+	tests := []struct {
+		memory uint64
+		ok     bool
+	}{
+		{1, true},
+		{maxMemory, true},
+		{maxMemory + 1, false},
+		{1 << 20, false},
+	}
+	for _, tt := range tests {
+		opts := databaseOptions{kdf: "argon2d", memory: tt.memory, iterations: 1, parallelism: 1}
+		k, err := newKdfParameters(opts)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("memory %d MiB: no error", tt.memory)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("memory %d MiB: %v", tt.memory, err)
+			continue
+		}
+		// gokeepasslib passes the memory to Argon2 like this, in KiB.
+		if got := uint64(uint32(k.Memory) / 1024); got != tt.memory*1024 {
+			t.Errorf("memory %d MiB is derived with %d KiB", tt.memory, got)
+		}
+	}
+}
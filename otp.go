@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+)
+
+const (
+	otpField            = "otp"
+	timeOtpPrefix       = "TimeOtp-"
+	hmacOtpPrefix       = "HmacOtp-"
+	defaultOTPDigits    = 6
+	defaultOTPPeriod    = 30
+	defaultOTPAlgorithm = "SHA1"
+)
+
+var errNoOTP = errors.New("entry has no one-time password")
+
+// otpConfig describes how the one-time passwords of an entry are computed,
+// either as RFC 6238 TOTP or as RFC 4226 HOTP.
+type otpConfig struct {
+	secret    []byte
+	algorithm string
+	digits    int
+	period    int
+	counter   uint64
+	hotp      bool
+}
+
+func newOTPConfig() *otpConfig {
+	return &otpConfig{
+		algorithm: defaultOTPAlgorithm,
+		digits:    defaultOTPDigits,
+		period:    defaultOTPPeriod,
+	}
+}
+
+func (c *otpConfig) validate() error {
+	if len(c.secret) == 0 {
+		return errors.New("missing one-time password secret")
+	}
+	if c.hash() == nil {
+		return errors.New("unsupported one-time password algorithm: " + c.algorithm)
+	}
+	if c.digits < 6 || c.digits > 8 {
+		return errors.New("one-time passwords must have 6 to 8 digits")
+	}
+	if !c.hotp && c.period <= 0 {
+		return errors.New("one-time password period must be positive")
+	}
+	return nil
+}
+
+// algorithmName normalizes both the otpauth ("SHA256") and the KeePass
+// ("HMAC-SHA-256") spelling of the algorithm.
+func (c *otpConfig) algorithmName() string {
+	name := strings.ToUpper(strings.Replace(c.algorithm, "-", "", -1))
+	return strings.TrimPrefix(name, "HMAC")
+}
+
+func (c *otpConfig) hash() func() hash.Hash {
+	switch c.algorithmName() {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// hotpCode computes the RFC 4226 code for counter.
+func (c *otpConfig) hotpCode(counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(c.hash(), c.secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < c.digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", c.digits, value%mod)
+}
+
+// code returns the one-time password at now and, for TOTP, the time it
+// stays valid.
+func (c *otpConfig) code(now time.Time) (string, time.Duration) {
+	if c.hotp {
+		return c.hotpCode(c.counter), 0
+	}
+	unix := now.Unix()
+	period := int64(c.period)
+	remaining := time.Duration(period-unix%period) * time.Second
+	return c.hotpCode(uint64(unix / period)), remaining
+}
+
+func decodeBase32(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	s = strings.TrimRight(s, "=")
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+}
+
+func parseOTPURI(uri string) (*otpConfig, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth" || (u.Host != "totp" && u.Host != "hotp") {
+		return nil, errors.New("not an otpauth://totp or otpauth://hotp URI")
+	}
+
+	c := newOTPConfig()
+	c.hotp = u.Host == "hotp"
+	q := u.Query()
+	if c.secret, err = decodeBase32(q.Get("secret")); err != nil {
+		return nil, err
+	}
+	if v := q.Get("algorithm"); v != "" {
+		c.algorithm = v
+	}
+	if v := q.Get("digits"); v != "" {
+		if c.digits, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("period"); v != "" {
+		if c.period, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("counter"); v != "" {
+		if c.counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return c, c.validate()
+}
+
+// uri formats c as an otpauth URI as used by KeePassXC.
+func (c *otpConfig) uri(issuer string, account string) string {
+	q := url.Values{}
+	q.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.secret))
+	q.Set("algorithm", c.algorithmName())
+	q.Set("digits", strconv.Itoa(c.digits))
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	kind := "totp"
+	if c.hotp {
+		kind = "hotp"
+		q.Set("counter", strconv.FormatUint(c.counter, 10))
+	} else {
+		q.Set("period", strconv.Itoa(c.period))
+	}
+	label := issuer
+	if account != "" {
+		label += ":" + account
+	}
+	return (&url.URL{Scheme: "otpauth", Host: kind, Path: "/" + label, RawQuery: q.Encode()}).String()
+}
+
+// keepassSecret reads a secret stored by KeePass 2.47+ in one of the
+// prefix+"Secret" fields.
+func keepassSecret(entry *gokeepasslib.Entry, prefix string) ([]byte, bool, error) {
+	if v := getEntryValue(entry, prefix+"Secret-Base32"); v != nil {
+		secret, err := decodeBase32(v.Value.Content)
+		return secret, true, err
+	}
+	if v := getEntryValue(entry, prefix+"Secret-Hex"); v != nil {
+		secret, err := hex.DecodeString(strings.Join(strings.Fields(v.Value.Content), ""))
+		return secret, true, err
+	}
+	if v := getEntryValue(entry, prefix+"Secret-Base64"); v != nil {
+		secret, err := base64.StdEncoding.DecodeString(v.Value.Content)
+		return secret, true, err
+	}
+	if v := getEntryValue(entry, prefix+"Secret"); v != nil {
+		return []byte(v.Value.Content), true, nil
+	}
+	return nil, false, nil
+}
+
+func keepassInt(entry *gokeepasslib.Entry, key string, value *int) error {
+	if v := getEntryValue(entry, key); v != nil && v.Value.Content != "" {
+		i, err := strconv.Atoi(v.Value.Content)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		*value = i
+	}
+	return nil
+}
+
+// entryOTP reads the one-time password settings of entry, from a KeePassXC
+// otpauth URI in the otp field, or from the KeePass TimeOtp-* and HmacOtp-*
+// fields.
+func entryOTP(entry *gokeepasslib.Entry) (*otpConfig, error) {
+	if v := getEntryValue(entry, otpField); v != nil && v.Value.Content != "" {
+		return parseOTPURI(v.Value.Content)
+	}
+
+	c := newOTPConfig()
+	secret, found, err := keepassSecret(entry, timeOtpPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		c.secret = secret
+		if err := keepassInt(entry, timeOtpPrefix+"Length", &c.digits); err != nil {
+			return nil, err
+		}
+		if err := keepassInt(entry, timeOtpPrefix+"Period", &c.period); err != nil {
+			return nil, err
+		}
+		if v := getEntryValue(entry, timeOtpPrefix+"Algorithm"); v != nil && v.Value.Content != "" {
+			c.algorithm = v.Value.Content
+		}
+		return c, c.validate()
+	}
+
+	secret, found, err = keepassSecret(entry, hmacOtpPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		c.secret = secret
+		c.hotp = true
+		counter := 0
+		if err := keepassInt(entry, hmacOtpPrefix+"Counter", &counter); err != nil {
+			return nil, err
+		}
+		c.counter = uint64(counter)
+		return c, c.validate()
+	}
+	return nil, errNoOTP
+}
+
+// advanceHOTP stores the next counter value of an HOTP entry, so the same
+// code is never handed out twice.
+func advanceHOTP(entry *gokeepasslib.Entry, c *otpConfig) {
+	c.counter++
+	if v := getEntryValue(entry, otpField); v != nil && v.Value.Content != "" {
+		u, err := url.Parse(v.Value.Content)
+		if err == nil {
+			q := u.Query()
+			q.Set("counter", strconv.FormatUint(c.counter, 10))
+			u.RawQuery = q.Encode()
+			v.Value.Content = u.String()
+		}
+		return
+	}
+	setEntryContent(entry, hmacOtpPrefix+"Counter", strconv.FormatUint(c.counter, 10))
+}
+
+func isOTPField(key string) bool {
+	return strings.EqualFold(key, otpField) ||
+		strings.HasPrefix(key, timeOtpPrefix) ||
+		strings.HasPrefix(key, hmacOtpPrefix)
+}
+
+// setEntryOTP replaces the one-time password settings of entry with c, in
+// the KeePassXC ("keepassxc") or the KeePass 2.47+ ("keepass") format.
+func setEntryOTP(entry *gokeepasslib.Entry, c *otpConfig, format string) error {
+	var values []gokeepasslib.ValueData
+	for _, v := range entry.Values {
+		if !isOTPField(v.Key) {
+			values = append(values, v)
+		}
+	}
+
+	switch format {
+	case "keepassxc":
+		values = append(values, mkProtectedValue(otpField, c.uri(entry.GetTitle(), getEntryContent(*entry, "UserName"))))
+	case "keepass":
+		prefix := timeOtpPrefix
+		if c.hotp {
+			prefix = hmacOtpPrefix
+		}
+		secret := base32.StdEncoding.EncodeToString(c.secret)
+		values = append(values, mkProtectedValue(prefix+"Secret-Base32", secret))
+		if c.hotp {
+			if c.digits != defaultOTPDigits {
+				return errors.New("KeePass HOTP codes always have 6 digits")
+			}
+			values = append(values, mkValue(hmacOtpPrefix+"Counter", strconv.FormatUint(c.counter, 10)))
+			break
+		}
+		if c.digits != defaultOTPDigits {
+			values = append(values, mkValue(timeOtpPrefix+"Length", strconv.Itoa(c.digits)))
+		}
+		if c.period != defaultOTPPeriod {
+			values = append(values, mkValue(timeOtpPrefix+"Period", strconv.Itoa(c.period)))
+		}
+		if algorithm := c.algorithmName(); algorithm != defaultOTPAlgorithm {
+			values = append(values, mkValue(timeOtpPrefix+"Algorithm", "HMAC-SHA-"+strings.TrimPrefix(algorithm, "SHA")))
+		}
+	default:
+		return errors.New("unknown one-time password format: " + format)
+	}
+	entry.Values = values
+	return nil
+}
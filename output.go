@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+)
+
+var outputFormat = textOutput
+
+func setOutputFormat(format string) error {
+	if format != textOutput && format != jsonOutput {
+		return errors.New("output must be text or json")
+	}
+	outputFormat = format
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+type timesJSON struct {
+	Created  *time.Time `json:"created"`
+	Modified *time.Time `json:"modified"`
+	Accessed *time.Time `json:"accessed"`
+	Expires  *time.Time `json:"expires"`
+}
+
+type entryJSON struct {
+	Path        string            `json:"path"`
+	UUID        string            `json:"uuid"`
+	Title       string            `json:"title"`
+	UserName    string            `json:"username"`
+	Password    *string           `json:"password,omitempty"`
+	URL         string            `json:"url"`
+	Notes       string            `json:"notes"`
+	Tags        []string          `json:"tags"`
+	Fields      map[string]string `json:"fields"`
+	Attachments []string          `json:"attachments"`
+	Times       timesJSON         `json:"times"`
+}
+
+type groupJSON struct {
+	Path  string    `json:"path"`
+	UUID  string    `json:"uuid"`
+	Name  string    `json:"name"`
+	Times timesJSON `json:"times"`
+}
+
+type listingJSON struct {
+	groupJSON
+	Groups  []groupJSON `json:"groups"`
+	Entries []entryJSON `json:"entries"`
+}
+
+type treeJSON struct {
+	groupJSON
+	Groups  []treeJSON  `json:"groups"`
+	Entries []entryJSON `json:"entries"`
+}
+
+func uuidString(uuid gokeepasslib.UUID) string {
+	return hex.EncodeToString(uuid[:])
+}
+
+func jsonTime(t *w.TimeWrapper) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+func newTimesJSON(times gokeepasslib.TimeData) timesJSON {
+	result := timesJSON{
+		Created:  jsonTime(times.CreationTime),
+		Modified: jsonTime(times.LastModificationTime),
+		Accessed: jsonTime(times.LastAccessTime),
+	}
+	if times.Expires.Bool {
+		result.Expires = jsonTime(times.ExpiryTime)
+	}
+	return result
+}
+
+func splitTags(tags string) []string {
+	result := []string{}
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// newEntryJSON describes entry. Protected values are left out unless
+// secrets is set.
+func newEntryJSON(path string, entry *gokeepasslib.Entry, secrets bool) entryJSON {
+	result := entryJSON{
+		Path:        path,
+		UUID:        uuidString(entry.UUID),
+		Title:       entry.GetTitle(),
+		UserName:    getEntryContent(*entry, "UserName"),
+		URL:         getEntryContent(*entry, "URL"),
+		Notes:       getEntryContent(*entry, "Notes"),
+		Tags:        splitTags(entry.Tags),
+		Fields:      map[string]string{},
+		Attachments: []string{},
+		Times:       newTimesJSON(entry.Times),
+	}
+	if secrets {
+		password := entry.GetPassword()
+		result.Password = &password
+	}
+	for _, v := range entry.Values {
+		if isStandardField(v.Key) || (v.Value.Protected.Bool && !secrets) {
+			continue
+		}
+		result.Fields[v.Key] = v.Value.Content
+	}
+	for _, binary := range entry.Binaries {
+		result.Attachments = append(result.Attachments, binary.Name)
+	}
+	return result
+}
+
+func newGroupJSON(wg workingGroup) groupJSON {
+	group := wg.Group()
+	return groupJSON{
+		Path:  wg.String(),
+		UUID:  uuidString(group.UUID),
+		Name:  group.Name,
+		Times: newTimesJSON(group.Times),
+	}
+}
+
+func newListingJSON(wg workingGroup) (listingJSON, error) {
+	result := listingJSON{
+		groupJSON: newGroupJSON(wg),
+		Groups:    []groupJSON{},
+		Entries:   []entryJSON{},
+	}
+	group := wg.Group()
+	for _, subGroup := range group.Groups {
+		sub, err := wg.ChGroup(subGroup.Name)
+		if err != nil {
+			return result, err
+		}
+		result.Groups = append(result.Groups, newGroupJSON(sub))
+	}
+	for i := range group.Entries {
+		entry := &group.Entries[i]
+		result.Entries = append(result.Entries, newEntryJSON(entryPath(wg, entry.GetTitle()), entry, false))
+	}
+	return result, nil
+}
+
+// newTreeJSON describes wg and everything below it. Protected values are
+// left out unless secrets is set.
+func newTreeJSON(wg workingGroup, secrets bool) (treeJSON, error) {
+	result := treeJSON{
+		groupJSON: newGroupJSON(wg),
+		Groups:    []treeJSON{},
+		Entries:   []entryJSON{},
+	}
+	group := wg.Group()
+	for _, subGroup := range group.Groups {
+		sub, err := wg.ChGroup(subGroup.Name)
+		if err != nil {
+			return result, err
+		}
+		subTree, err := newTreeJSON(sub, secrets)
+		if err != nil {
+			return result, err
+		}
+		result.Groups = append(result.Groups, subTree)
+	}
+	for i := range group.Entries {
+		entry := &group.Entries[i]
+		result.Entries = append(result.Entries, newEntryJSON(entryPath(wg, entry.GetTitle()), entry, secrets))
+	}
+	return result, nil
+}
+
+type kdfJSON struct {
+	Name        string `json:"name"`
+	Rounds      uint64 `json:"rounds,omitempty"`
+	Memory      uint64 `json:"memory,omitempty"`
+	Iterations  uint64 `json:"iterations,omitempty"`
+	Parallelism uint32 `json:"parallelism,omitempty"`
+}
+
+type infoJSON struct {
+	Path        string  `json:"path"`
+	Name        string  `json:"name"`
+	Version     string  `json:"version"`
+	Cipher      string  `json:"cipher"`
+	KDF         kdfJSON `json:"kdf"`
+	Compression string  `json:"compression"`
+	Groups      int     `json:"groups"`
+	Entries     int     `json:"entries"`
+	Modified    bool    `json:"modified"`
+}
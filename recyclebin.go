@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/tobischo/gokeepasslib/v3"
+	w "github.com/tobischo/gokeepasslib/v3/wrappers"
+)
+
+const recycleBinIconID = 43
+
+func findGroupByUUID(group *gokeepasslib.Group, uuid gokeepasslib.UUID) *gokeepasslib.Group {
+	if group.UUID.Compare(uuid) {
+		return group
+	}
+	for i := range group.Groups {
+		if g := findGroupByUUID(&group.Groups[i], uuid); g != nil {
+			return g
+		}
+	}
+	return nil
+}
+
+// recycleBinEnabled reports whether deleted objects should be moved to the
+// recycle bin. Like KeePass, a database that does not say is treated as
+// having it enabled.
+func recycleBinEnabled(d *gokeepasslib.Database) bool {
+	enabled := d.Content.Meta.RecycleBinEnabled
+	return !enabled.Valid || enabled.Bool
+}
+
+// recycleBin returns the recycle bin group of d, creating it under the root
+// group if it does not exist yet.
+func recycleBin(d *gokeepasslib.Database) *gokeepasslib.Group {
+	meta := d.Content.Meta
+	root := &d.Content.Root.Groups[0]
+	if g := findGroupByUUID(root, meta.RecycleBinUUID); g != nil {
+		return g
+	}
+
+	bin := newGroup(d)
+	bin.Name = "Recycle Bin"
+	bin.IconID = recycleBinIconID
+	bin.EnableAutoType = w.NewBoolWrapper(false)
+	bin.EnableSearching = w.NewBoolWrapper(false)
+	root.Groups = append(root.Groups, bin)
+
+	changed := timeNow(d)
+	meta.RecycleBinEnabled = w.NewBoolWrapper(true)
+	meta.RecycleBinUUID = bin.UUID
+	meta.RecycleBinChanged = &changed
+	return &root.Groups[len(root.Groups)-1]
+}
+
+// inRecycleBin reports whether wg is the recycle bin or one of its
+// descendants.
+func inRecycleBin(d *gokeepasslib.Database, wg workingGroup) bool {
+	for ; wg != nil; wg = wg.Prev() {
+		if wg.Group().UUID.Compare(d.Content.Meta.RecycleBinUUID) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDeletedObject adds uuid to the deleted objects of d so that
+// synchronizing with another copy of the database does not bring it back.
+func recordDeletedObject(d *gokeepasslib.Database, uuid gokeepasslib.UUID) {
+	deletionTime := timeNow(d)
+	d.Content.Root.DeletedObjects = append(d.Content.Root.DeletedObjects,
+		gokeepasslib.DeletedObjectData{UUID: uuid, DeletionTime: &deletionTime})
+}
+
+func recordDeletedGroup(d *gokeepasslib.Database, group gokeepasslib.Group) {
+	for _, entry := range group.Entries {
+		recordDeletedObject(d, entry.UUID)
+	}
+	for _, subGroup := range group.Groups {
+		recordDeletedGroup(d, subGroup)
+	}
+	recordDeletedObject(d, group.UUID)
+}
+
+// removeEntry deletes the entry at index i of the group wg. The entry is
+// moved to the recycle bin, unless the bin is disabled or the entry is
+// already in it, in which case it is removed permanently.
+func removeEntry(d *gokeepasslib.Database, wg workingGroup, i int) {
+	recycle := recycleBinEnabled(d) && !inRecycleBin(d, wg)
+	group := wg.Group()
+	entry := group.Entries[i]
+	group.Entries = append(group.Entries[:i], group.Entries[i+1:]...)
+
+	if recycle {
+		locationChanged := timeNow(d)
+		entry.Times.LocationChanged = &locationChanged
+		bin := recycleBin(d)
+		bin.Entries = append(bin.Entries, entry)
+	} else {
+		recordDeletedObject(d, entry.UUID)
+	}
+}
+
+// removeGroup deletes the group wg together with its descendants, following
+// the same rules as removeEntry.
+func removeGroup(d *gokeepasslib.Database, wg workingGroup) {
+	recycle := recycleBinEnabled(d) && !inRecycleBin(d, wg)
+	group := *wg.Group()
+	parent := wg.Prev().Group()
+	for i := range parent.Groups {
+		if parent.Groups[i].UUID.Compare(group.UUID) {
+			parent.Groups = append(parent.Groups[:i], parent.Groups[i+1:]...)
+			break
+		}
+	}
+
+	if recycle {
+		locationChanged := timeNow(d)
+		group.Times.LocationChanged = &locationChanged
+		bin := recycleBin(d)
+		bin.Groups = append(bin.Groups, group)
+	} else {
+		recordDeletedGroup(d, group)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/rogaps/kp2cli/terminal"
+)
+
+const maxSourceDepth = 16
+
+var (
+	errexit     bool
+	sourceDepth int
+)
+
+// continued reports whether line ends with an unescaped backslash.
+func continued(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+	return n%2 == 1
+}
+
+// sourceFile runs the commands in the file at path as if they were typed at
+// the prompt. Empty lines and lines starting with # are skipped, and a line
+// ending with a backslash continues on the next one. Failing commands are
+// reported with their line number; with errexit set, the first one stops the
+// script.
+func sourceFile(t *terminal.Term, path string) error {
+	if sourceDepth >= maxSourceDepth {
+		return errors.New("source nested too deeply")
+	}
+	sourceDepth++
+	defer func() { sourceDepth-- }()
+
+	expandedPath, err := homedir.Expand(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(expandedPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var failed int
+	run := func(cmd string, lineNo int) error {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" || strings.HasPrefix(cmd, "#") {
+			return nil
+		}
+		err := t.CallCmd(cmd)
+		if err == nil || !t.Running() {
+			return nil
+		}
+		err = fmt.Errorf("%s:%d: %s: %v", path, lineNo, cmd, err)
+		if errexit {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, err)
+		failed++
+		return nil
+	}
+
+	var cmd string
+	var lineNo, cmdLineNo int
+	scanner := bufio.NewScanner(f)
+	for t.Running() && scanner.Scan() {
+		lineNo++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if cmd == "" {
+			cmdLineNo = lineNo
+		}
+		if continued(line) {
+			cmd += line[:len(line)-1]
+			continue
+		}
+		if err := run(cmd+line, cmdLineNo); err != nil {
+			return err
+		}
+		cmd = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := run(cmd, cmdLineNo); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%s: %d command(s) failed", path, failed)
+	}
+	return nil
+}
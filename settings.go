@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+type setting struct {
+	name string
+	help string
+	get  func() string
+	set  func(value string) error
+}
+
+func parseOnOff(name string, value string) (bool, error) {
+	switch value {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, errors.New(name + " must be on or off")
+}
+
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+var settings = []setting{
+	{
+		name: "clipboard-timeout",
+		help: "Seconds before a copied value is cleared from the clipboard, 0 to keep it",
+		get: func() string {
+			return strconv.Itoa(int(clipboardTimeout / time.Second))
+		},
+		set: func(value string) error {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return errors.New("clipboard-timeout must be a number of seconds")
+			}
+			clipboardTimeout = time.Duration(seconds) * time.Second
+			return nil
+		},
+	},
+	{
+		name: "errexit",
+		help: "Stop a sourced script at the first failing command (set -e / set +e)",
+		get: func() string {
+			return onOff(errexit)
+		},
+		set: func(value string) (err error) {
+			errexit, err = parseOnOff("errexit", value)
+			return err
+		},
+	},
+	{
+		name: "output",
+		help: "Output format of ls, find, show, tree and info (text or json)",
+		get: func() string {
+			return outputFormat
+		},
+		set: setOutputFormat,
+	},
+}
+
+func findSetting(name string) *setting {
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i]
+		}
+	}
+	return nil
+}
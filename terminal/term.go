@@ -15,6 +15,8 @@ type Term struct {
 	prompt         string
 	Cmds           []Command
 	historyFile    string
+	exitHandler    func()
+	quitHandler    func() bool
 	running        uint32
 	interruptCount uint32
 }
@@ -70,6 +72,19 @@ func (t *Term) SetHistoryFile(path string) {
 	t.historyFile = path
 }
 
+// SetExitHandler sets a function that is called once when the terminal
+// stops, whether by a command, end of input or interrupts.
+func (t *Term) SetExitHandler(f func()) {
+	t.exitHandler = f
+}
+
+// SetQuitHandler sets a function that is called before the terminal stops
+// at the end of input or after interrupts. Returning false keeps it
+// running.
+func (t *Term) SetQuitHandler(f func() bool) {
+	t.quitHandler = f
+}
+
 func (t *Term) SetCommands(cmds ...Command) {
 	t.Cmds = cmds
 }
@@ -78,6 +93,10 @@ func (t *Term) SetPrompt(prompt string) {
 	t.prompt = prompt
 }
 
+func (t *Term) Running() bool {
+	return atomic.LoadUint32(&t.running) == 1
+}
+
 func (t *Term) Stop() {
 	atomic.CompareAndSwapUint32(&t.running, 1, 0)
 }
@@ -115,28 +134,34 @@ func (t *Term) Run() {
 			t.handleError(line, err)
 		}
 
-		if err := t.callCmd(line); err != nil {
+		if err := t.CallCmd(line); err != nil {
 			t.handleCmdError(err)
 		}
 	}
+	t.handleExit()
 }
 
 func (t *Term) handleEOF(line string, err error) {
 	fmt.Println("exit")
-	t.handleExit()
-	t.Stop()
+	t.quit()
 }
 
 func (t *Term) handleInterrupt(line string, err error) {
 	if t.interruptCount >= 2 {
 		fmt.Println("interrupted")
-		t.handleExit()
-		t.Stop()
+		t.interruptCount = 0
+		t.quit()
 	} else {
 		fmt.Println("Press ctrl-c once more to exit")
 	}
 }
 
+func (t *Term) quit() {
+	if t.quitHandler == nil || t.quitHandler() {
+		t.Stop()
+	}
+}
+
 func (t *Term) handleError(line string, err error) {
 	fmt.Println("prompt for input failed")
 	t.handleExit()
@@ -160,6 +185,9 @@ func (t *Term) promptForInput() (string, error) {
 }
 
 func (t *Term) handleExit() {
+	if t.exitHandler != nil {
+		t.exitHandler()
+	}
 	if f, err := os.OpenFile(t.historyFile, os.O_RDWR, 0666); err == nil {
 		_, err = t.Line.WriteHistory(f)
 		if err != nil {
@@ -178,7 +206,8 @@ func (t *Term) findCmd(line string) *Command {
 	return &noCmdAvailable
 }
 
-func (t *Term) callCmd(line string) error {
+// CallCmd runs line as if it was typed at the prompt.
+func (t *Term) CallCmd(line string) error {
 
 	words := LineSplit(line)
 	if len(words) > 0 {
@@ -9,6 +9,9 @@ import (
 
 var (
 	errNoSuchGroup = errors.New("no such group")
+	errNoSuchEntry = errors.New("no such entry")
+	errEntryExists = errors.New("entry already exists")
+	errGroupExists = errors.New("group already exists")
 )
 
 type workingGroup interface {
@@ -32,9 +35,10 @@ func (rg *rootGroup) Prev() workingGroup {
 
 func (rg *rootGroup) ChGroup(name string) (workingGroup, error) {
 	var g *gokeepasslib.Group
-	for _, subGroup := range rg.Group().Groups {
-		if subGroup.Name == name {
-			g = &subGroup
+	groups := rg.Group().Groups
+	for i := range groups {
+		if groups[i].Name == name {
+			g = &groups[i]
 			break
 		}
 	}
@@ -69,9 +73,10 @@ func (sg *subGroup) Prev() workingGroup {
 
 func (sg *subGroup) ChGroup(name string) (workingGroup, error) {
 	var g *gokeepasslib.Group
-	for _, subGroup := range sg.Group().Groups {
-		if subGroup.Name == name {
-			g = &subGroup
+	groups := sg.Group().Groups
+	for i := range groups {
+		if groups[i].Name == name {
+			g = &groups[i]
 			break
 		}
 	}
@@ -88,6 +93,11 @@ func (sg *subGroup) String() string {
 
 func travel(cwd workingGroup, path string) (workingGroup, error) {
 	var err error
+	if strings.HasPrefix(path, "/") {
+		for cwd.Prev() != nil {
+			cwd = cwd.Prev()
+		}
+	}
 	parts := strings.Split(path, "/")
 	for i := 0; err == nil && cwd != nil && i < len(parts); i++ {
 		if parts[i] == "" {